@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"voice-chat-server/config"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+)
+
+const commandsUsage = `usage: %s [command] [flags]
+
+commands:
+  serve                                  run the server, the default without a command
+  migrate up|down|status                 apply, revert or list the schema migrations
+  user create|passwd|disable|list        manage the accounts
+  server create|list                     manage the servers
+  room create|list|delete                manage the rooms of a server
+  session list|revoke                    list or end the sessions of the users
+
+run a command with -h for its flags, every command takes the flags of the config too.
+`
+
+// commands maps the name of a command to its function, which gets the arguments after the
+// name and returns the exit code.
+var commands = map[string]func(args []string) int{
+	"serve":   serve,
+	"migrate": runMigrate,
+	"user": func(args []string) int {
+		return runActions("user", userActions, args)
+	},
+	"server": func(args []string) int {
+		return runActions("server", serverActions, args)
+	},
+	"room": func(args []string) int {
+		return runActions("room", roomActions, args)
+	},
+	"session": func(args []string) int {
+		return runActions("session", sessionActions, args)
+	},
+}
+
+// runCommand runs the command named by the first argument, starting the server when the
+// arguments begin with a flag or are empty like before the commands existed.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return serve(args)
+	}
+	if args[0] == "help" {
+		fmt.Printf(commandsUsage, os.Args[0])
+		return 0
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+		_, _ = fmt.Fprintf(os.Stderr, commandsUsage, os.Args[0])
+		return 2
+	}
+	return command(args[1:])
+}
+
+// action is what an administration command like user create does. Setup defines the flags
+// of the action and returns the function running it with the remaining arguments, once the
+// services are ready.
+type action struct {
+	name  string
+	args  []string
+	help  string
+	setup func(flags *flag.FlagSet) func(args []string) error
+}
+
+func runActions(command string, actions []action, args []string) int {
+	usage := func() {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: %s %s <action> [flags]\n\nactions:\n", os.Args[0], command)
+		for _, a := range actions {
+			_, _ = fmt.Fprintf(os.Stderr, "  %-28s %s\n", strings.Join(append([]string{a.name}, a.args...), " "), a.help)
+		}
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	var selected *action
+	for i := range actions {
+		if actions[i].name == args[0] {
+			selected = &actions[i]
+		}
+	}
+	if selected == nil {
+		usage()
+		return 2
+	}
+
+	flags := flag.NewFlagSet(os.Args[0]+" "+command+" "+selected.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "usage: %s [flags] %s\n\n%s\n\nflags:\n",
+			flags.Name(), strings.Join(selected.args, " "), selected.help)
+		flags.PrintDefaults()
+	}
+	run := selected.setup(flags)
+	cfg, err := config.LoadFlags(flags, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if flags.NArg() != len(selected.args) {
+		flags.Usage()
+		return 2
+	}
+
+	logger.InitQuiet()
+	err = openServices(cfg)
+	if store != nil {
+		defer store.Close()
+	}
+	if err == nil {
+		err = run(flags.Args())
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// table prints rows aligned in columns.
+func table(header string, rows func(w io.Writer)) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, header)
+	rows(w)
+	_ = w.Flush()
+}
+
+func formatTime(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).Format(time.RFC3339)
+}
+
+func parseId(value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid id: %s", value)
+	}
+	return id, nil
+}
+
+func findUser(username string) (*models.ChatUser, error) {
+	user := chatUserService.GetUserByUsername(username)
+	if user == nil {
+		return nil, errors.New("can not find user " + username)
+	}
+	return user, nil
+}
+
+// readPassword asks twice for a password on a terminal without echoing it, otherwise it
+// reads the first line of the standard input so scripts can pipe it in.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	var passwords [2]string
+	for i, prompt := range []string{"Password: ", "Retype password: "} {
+		_, _ = fmt.Fprint(os.Stderr, prompt)
+		password, err := terminal.ReadPassword(fd)
+		_, _ = fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		passwords[i] = string(password)
+	}
+	if passwords[0] != passwords[1] {
+		return "", errors.New("passwords do not match")
+	}
+	return passwords[0], nil
+}
+
+var userActions = []action{
+	{
+		name: "create",
+		args: []string{"USERNAME"},
+		help: "create an account whatever auth.registration is, the password is read from the input",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			name := flags.String("name", "", "display name (default the username)")
+			return func(args []string) error {
+				password, err := readPassword()
+				if err != nil {
+					return err
+				}
+				user, err := chatUserService.CreateUser(args[0], password, *name)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("created user %d %s\n", user.Id, user.UserName)
+				return nil
+			}
+		},
+	},
+	{
+		name: "passwd",
+		args: []string{"USERNAME"},
+		help: "set the password of a user read from the input and end the user's session",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				user, err := findUser(args[0])
+				if err != nil {
+					return err
+				}
+				password, err := readPassword()
+				if err != nil {
+					return err
+				}
+				if err = chatUserService.ResetPassword(user, password); err != nil {
+					return err
+				}
+				sessionService.DeleteByUserName(user.UserName)
+				fmt.Printf("password of %s updated\n", user.UserName)
+				return nil
+			}
+		},
+	},
+	{
+		name: "disable",
+		args: []string{"USERNAME"},
+		help: "disable a user and end the user's session, or enable the user again",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			enable := flags.Bool("enable", false, "enable the user instead")
+			return func(args []string) error {
+				user, err := findUser(args[0])
+				if err != nil {
+					return err
+				}
+				if err = chatUserService.SetDisabled(user, !*enable); err != nil {
+					return err
+				}
+				if *enable {
+					fmt.Printf("%s enabled\n", user.UserName)
+					return nil
+				}
+				sessionService.DeleteByUserName(user.UserName)
+				fmt.Printf("%s disabled\n", user.UserName)
+				return nil
+			}
+		},
+	},
+	{
+		name: "list",
+		help: "list the users",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				users, err := chatUserService.ListUsers()
+				if err != nil {
+					return err
+				}
+				table("ID\tUSERNAME\tNAME\tDISABLED", func(w io.Writer) {
+					for _, user := range users {
+						_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", user.Id, user.UserName, user.Name, user.Disabled)
+					}
+				})
+				return nil
+			}
+		},
+	},
+}
+
+var serverActions = []action{
+	{
+		name: "create",
+		args: []string{"NAME"},
+		help: "create a server owned by a user",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			description := flags.String("description", "", "description of the server")
+			position := flags.Int("position", 0, "position in the server list")
+			public := flags.Bool("public", false, "let every user join the server")
+			owner := flags.String("owner", "admin", "username of the owner")
+			return func(args []string) error {
+				user, err := findUser(*owner)
+				if err != nil {
+					return err
+				}
+				server := models.ChatServer{
+					Name:        args[0],
+					Description: *description,
+					Position:    *position,
+					Public:      *public,
+				}
+				if err = chatServerService.CreateServer(&server); err != nil {
+					return err
+				}
+				if err = chatServerService.AddMember(server.Id, user.Id); err != nil {
+					return err
+				}
+				if err = permissionService.AssignServerRole(user.Id, server.Id, models.RoleOwner); err != nil {
+					return err
+				}
+				fmt.Printf("created server %d %s owned by %s\n", server.Id, server.Name, user.UserName)
+				return nil
+			}
+		},
+	},
+	{
+		name: "list",
+		help: "list the servers",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				servers := chatServerService.ListServers()
+				table("ID\tNAME\tPOSITION\tPUBLIC\tDESCRIPTION", func(w io.Writer) {
+					for _, server := range servers {
+						_, _ = fmt.Fprintf(w, "%d\t%s\t%d\t%t\t%s\n",
+							server.Id, server.Name, server.Position, server.Public, server.Description)
+					}
+				})
+				return nil
+			}
+		},
+	},
+}
+
+var roomActions = []action{
+	{
+		name: "create",
+		args: []string{"SERVER_ID", "NAME"},
+		help: "create a room in a server",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			description := flags.String("description", "", "description of the room")
+			mediaMode := flags.String("media-mode", "", "relay, sfu or mesh, empty for the default mode")
+			position := flags.Int("position", 0, "position in the room list")
+			capacity := flags.Int("capacity", 0, "most users in the room, 0 for no limit")
+			maxSpeakers := flags.Int("max-speakers", 0, "most users speaking at once, 0 for no limit")
+			waitingQueue := flags.Bool("waiting-queue", false, "queue users while the room is full")
+			private := flags.Bool("private", false, "only let users with access in")
+			return func(args []string) error {
+				serverId, err := parseId(args[0])
+				if err != nil {
+					return err
+				}
+				room := models.ChatRoom{
+					Name:         args[1],
+					Description:  *description,
+					MediaMode:    *mediaMode,
+					Position:     *position,
+					Capacity:     *capacity,
+					MaxSpeakers:  *maxSpeakers,
+					WaitingQueue: *waitingQueue,
+					Private:      *private,
+					ServerId:     serverId,
+				}
+				if err = chatServerService.CreateRoom(&room); err != nil {
+					return err
+				}
+				fmt.Printf("created room %d %s in server %d\n", room.Id, room.Name, serverId)
+				return nil
+			}
+		},
+	},
+	{
+		name: "list",
+		args: []string{"SERVER_ID"},
+		help: "list the rooms of a server",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				serverId, err := parseId(args[0])
+				if err != nil {
+					return err
+				}
+				rooms, err := chatServerService.ListRooms(serverId)
+				if err != nil {
+					return err
+				}
+				table("ID\tNAME\tPOSITION\tCAPACITY\tPRIVATE\tLOCKED\tMEDIA", func(w io.Writer) {
+					for _, room := range rooms {
+						_, _ = fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%t\t%t\t%s\n", room.Id, room.Name, room.Position,
+							room.Capacity, room.Private, room.Locked, room.MediaMode)
+					}
+				})
+				return nil
+			}
+		},
+	},
+	{
+		name: "delete",
+		args: []string{"ROOM_ID"},
+		help: "delete a room, users connected to a running server stay until they leave",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				roomId, err := parseId(args[0])
+				if err != nil {
+					return err
+				}
+				if chatServerService.GetRoomById(roomId) == nil {
+					return errors.New("can not find room " + args[0])
+				}
+				if err = chatServerService.DeleteRoom(roomId); err != nil {
+					return err
+				}
+				fmt.Printf("deleted room %d\n", roomId)
+				return nil
+			}
+		},
+	},
+}
+
+var sessionActions = []action{
+	{
+		name: "list",
+		help: "list the sessions",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				sessions, err := sessionService.ListSessions()
+				if err != nil {
+					return err
+				}
+				table("USERNAME\tCREATED\tEXPIRES", func(w io.Writer) {
+					for _, session := range sessions {
+						_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n",
+							session.UserName, formatTime(session.CreateAt), formatTime(session.Expires))
+					}
+				})
+				return nil
+			}
+		},
+	},
+	{
+		name: "revoke",
+		args: []string{"USERNAME"},
+		help: "end the session of a user and revoke the user's refresh tokens",
+		setup: func(flags *flag.FlagSet) func(args []string) error {
+			return func(args []string) error {
+				user, err := findUser(args[0])
+				if err != nil {
+					return err
+				}
+				sessionService.DeleteByUserName(user.UserName)
+				fmt.Printf("sessions of %s revoked\n", user.UserName)
+				return nil
+			}
+		},
+	},
+}
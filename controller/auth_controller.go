@@ -4,9 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"github.com/dgrijalva/jwt-go"
 	"net/http"
-	"time"
 	"voice-chat-server/dto"
 	"voice-chat-server/logger"
 	"voice-chat-server/models"
@@ -17,6 +15,7 @@ import (
 type AuthController struct {
 	UserService *service.ChatUserService
 	Session     *service.SessionService
+	Permissions *service.PermissionService
 }
 
 func (controller *AuthController) DoLogin(w http.ResponseWriter, r *http.Request) {
@@ -43,28 +42,47 @@ func (controller *AuthController) DoLogin(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := make(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(1)).Unix()
-	claims["iat"] = time.Now().Unix()
-	token.Claims = claims
-
-	tokenString, err := token.SignedString([]byte(auth.SecretKey))
+	response, err := controller.Session.Login(user)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = fmt.Fprintln(w, "Error while signing the token")
-		logger.Logger.Fatal(err)
+		_, _ = fmt.Fprintln(w, err.Error())
+		return
 	}
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
 
-	controller.Session.Create(user, tokenString)
-
-	response := dto.JwtToken{Token: tokenString}
+func (controller *AuthController) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var request dto.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "Error in request")
+		return
+	}
+	response, err := controller.Session.Refresh(request.RefreshToken)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
 		logger.Logger.Error("encode failed:", err)
 	}
 }
 
+func (controller *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	controller.Session.DeleteByUserName(user.UserName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (controller *AuthController) ValidateToken(w http.ResponseWriter, r *http.Request) bool {
 	token := auth.GetTokenFromRequest(r)
 	if token != nil {
@@ -81,8 +99,9 @@ func (controller *AuthController) GetAuthInfo(w http.ResponseWriter, r *http.Req
 		return
 	}
 	authInfo := dto.AuthInfo{
-		Username:    user.UserName,
-		Authorities: make([]dto.AuthAuthority, 0),
+		Username:      user.UserName,
+		Administrator: controller.UserService.IsAdministrator(user),
+		Authorities:   controller.Permissions.Authorities(user),
 	}
 	err := json.NewEncoder(w).Encode(&authInfo)
 	if err != nil {
@@ -2,19 +2,35 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
 	"strconv"
+	"voice-chat-server/dto"
 	"voice-chat-server/logger"
+	"voice-chat-server/models"
 	"voice-chat-server/service"
 )
 
 type ChatServerController struct {
+	Session           *service.SessionService
+	UserService       *service.ChatUserService
 	ChatServerService *service.ChatServerService
+	Permissions       *service.PermissionService
+	ConnectionManager *service.ChatRoomConnectionManager
 }
 
 func (controller *ChatServerController) ListServers(w http.ResponseWriter, r *http.Request) {
-	serverList := controller.ChatServerService.ListServers()
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	var serverList []models.ChatServerData
+	if controller.UserService.IsAdministrator(user) {
+		serverList = controller.ChatServerService.ListServers()
+	} else {
+		serverList = controller.ChatServerService.ListServersOf(user.Id)
+	}
 	err := json.NewEncoder(w).Encode(serverList)
 	if err != nil {
 		logger.Logger.Error("encode failed:", err)
@@ -26,7 +42,16 @@ func writeErrResponse(w http.ResponseWriter, err error) {
 	_, _ = w.Write([]byte(err.Error()))
 }
 
+// isMember tells whether the user may see the server, the site administrator sees them all.
+func (controller *ChatServerController) isMember(user *models.ChatUser, serverId int64) bool {
+	return controller.UserService.IsAdministrator(user) || controller.ChatServerService.IsMember(serverId, user.Id)
+}
+
 func (controller *ChatServerController) GetServerInfo(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
 	params := mux.Vars(r)
 	id := params["id"]
 	idInt, err := strconv.ParseInt(id, 10, 64)
@@ -35,6 +60,10 @@ func (controller *ChatServerController) GetServerInfo(w http.ResponseWriter, r *
 		writeErrResponse(w, err)
 		return
 	}
+	if !controller.isMember(user, idInt) {
+		writeForbidden(w)
+		return
+	}
 	serverInfo, err := controller.ChatServerService.GetServerInfo(idInt)
 	if err != nil {
 		logger.Logger.Error(err)
@@ -49,6 +78,10 @@ func (controller *ChatServerController) GetServerInfo(w http.ResponseWriter, r *
 }
 
 func (controller *ChatServerController) ListRooms(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
 	serverId := r.FormValue("id")
 
 	idInt, err := strconv.ParseInt(serverId, 10, 64)
@@ -57,6 +90,10 @@ func (controller *ChatServerController) ListRooms(w http.ResponseWriter, r *http
 		writeErrResponse(w, err)
 		return
 	}
+	if !controller.isMember(user, idInt) {
+		writeForbidden(w)
+		return
+	}
 	roomList, err := controller.ChatServerService.ListRooms(idInt)
 	if err != nil {
 		logger.Logger.Error(err)
@@ -69,3 +106,323 @@ func (controller *ChatServerController) ListRooms(w http.ResponseWriter, r *http
 		writeErrResponse(w, err)
 	}
 }
+
+func (controller *ChatServerController) ListRoomMembers(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	params := mux.Vars(r)
+	idInt, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		logger.Logger.Error(err)
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(idInt)
+	if err != nil {
+		logger.Logger.Error(err)
+		writeErrResponse(w, err)
+		return
+	}
+	// who is in a private room is as private as the room itself
+	if room.Private && !controller.ChatServerService.HasRoomAccess(room.Id, user.Id) &&
+		!controller.Permissions.HasPermission(user, room.ServerId, room.Id, models.PermissionManageRooms) {
+		writeForbidden(w)
+		return
+	}
+	members := controller.ConnectionManager.RoomMembers(room.Id)
+	err = json.NewEncoder(w).Encode(members)
+	if err != nil {
+		logger.Logger.Error(err)
+		writeErrResponse(w, err)
+	}
+}
+
+func (controller *ChatServerController) CreateServer(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	var request dto.ServerRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	server := models.ChatServer{
+		Name:        request.Name,
+		Description: request.Description,
+		Position:    request.Position,
+		Public:      request.Public,
+	}
+	err = controller.ChatServerService.CreateServer(&server)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = controller.ChatServerService.AddMember(server.Id, user.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+	err = controller.Permissions.AssignServerRole(user.Id, server.Id, models.RoleOwner)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+	logger.Logger.Infof("Server %d created by %s", server.Id, user.UserName)
+
+	serverInfo, err := controller.ChatServerService.GetServerInfo(server.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(serverInfo)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (controller *ChatServerController) UpdateServer(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	server := controller.ChatServerService.GetServerById(serverId)
+	if server == nil {
+		writeErrResponse(w, errors.New("can not find server"))
+		return
+	}
+	var request dto.ServerRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	server.Name = request.Name
+	server.Description = request.Description
+	server.Position = request.Position
+	server.Public = request.Public
+	err = controller.ChatServerService.UpdateServer(server)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	serverInfo, err := controller.ChatServerService.GetServerInfo(server.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(serverInfo)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (controller *ChatServerController) DeleteServer(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	// deleting first turns away new connections, the ones already in the rooms are closed after,
+	// a server without rooms has none to close
+	rooms, _ := controller.ChatServerService.ListRooms(serverId)
+	err = controller.ChatServerService.DeleteServer(serverId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	for _, room := range rooms {
+		controller.ConnectionManager.DisconnectRoom(room.Id, service.CloseRoomDeleted, "server deleted")
+	}
+	logger.Logger.Infof("Server %d deleted", serverId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *ChatServerController) CreateRoom(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	var request dto.RoomRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	room := models.ChatRoom{
+		Name:         request.Name,
+		Description:  request.Description,
+		MediaMode:    request.MediaMode,
+		Mixing:       request.Mixing,
+		Position:     request.Position,
+		Capacity:     request.Capacity,
+		MaxSpeakers:  request.MaxSpeakers,
+		WaitingQueue: request.WaitingQueue,
+		Private:      request.Private,
+		ServerId:     serverId,
+	}
+	err = controller.setRoomPassword(&room, request.Password)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = controller.ChatServerService.CreateRoom(&room)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	logger.Logger.Infof("Room %d created in server %d", room.Id, serverId)
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&room)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (controller *ChatServerController) setRoomPassword(room *models.ChatRoom, encoded *string) error {
+	if encoded == nil {
+		return nil
+	}
+	password, err := decodePassword(*encoded)
+	if err != nil {
+		return err
+	}
+	return controller.ChatServerService.SetRoomPassword(room, password)
+}
+
+func (controller *ChatServerController) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	var request dto.RoomRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	room.Name = request.Name
+	room.Description = request.Description
+	room.MediaMode = request.MediaMode
+	room.Mixing = request.Mixing
+	room.Position = request.Position
+	room.Capacity = request.Capacity
+	room.MaxSpeakers = request.MaxSpeakers
+	room.WaitingQueue = request.WaitingQueue
+	room.Private = request.Private
+	err = controller.setRoomPassword(room, request.Password)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = controller.ChatServerService.UpdateRoom(room)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.UpdateRoom(room)
+	err = json.NewEncoder(w).Encode(room)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (controller *ChatServerController) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	// deleting first turns away new connections, the ones already in the room are closed after
+	err = controller.ChatServerService.DeleteRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	closed := controller.ConnectionManager.DisconnectRoom(roomId, service.CloseRoomDeleted, "room deleted")
+	logger.Logger.Infof("Room %d deleted, %d connections closed", roomId, closed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *ChatServerController) ListRoomAccess(w http.ResponseWriter, r *http.Request) {
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	access, err := controller.ChatServerService.ListRoomAccess(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(access)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (controller *ChatServerController) GrantRoomAccess(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	userId, err := parseIdParam(r, "userId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	if controller.UserService.GetUserById(userId) == nil {
+		writeErrResponse(w, errors.New("can not find user"))
+		return
+	}
+	err = controller.ChatServerService.GrantRoomAccess(roomId, userId, user.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *ChatServerController) RevokeRoomAccess(w http.ResponseWriter, r *http.Request) {
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	userId, err := parseIdParam(r, "userId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = controller.ChatServerService.RevokeRoomAccess(room.Id, userId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	user := controller.UserService.GetUserById(userId)
+	if room.Private && user != nil &&
+		!controller.Permissions.HasPermission(user, room.ServerId, room.Id, models.PermissionManageRooms) {
+		controller.ConnectionManager.DisconnectUser(room.ServerId, room.Id, userId, service.CloseAccessRevoked, "room access revoked")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
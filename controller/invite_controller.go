@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+type InviteController struct {
+	Session           *service.SessionService
+	ChatServerService *service.ChatServerService
+	Permissions       *service.PermissionService
+	InviteService     *service.InviteService
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+func (controller *InviteController) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	var request dto.InviteRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Duration < 0 {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	if request.RoomId != 0 {
+		room, err := controller.ChatServerService.GetRoom(request.RoomId)
+		if err != nil || room.ServerId != serverId {
+			writeErrResponse(w, errors.New("can not find room"))
+			return
+		}
+	}
+	if request.RoleId != 0 {
+		role := controller.Permissions.GetRole(request.RoleId)
+		if role == nil {
+			writeErrResponse(w, errors.New("can not find role"))
+			return
+		}
+		// an invite must not grant more than its creator holds
+		if role.Permissions&^controller.Permissions.Permissions(user, serverId, request.RoomId) != 0 {
+			writeForbidden(w)
+			return
+		}
+	}
+
+	invite := models.ChatInvite{
+		ServerId:  serverId,
+		RoomId:    request.RoomId,
+		RoleId:    request.RoleId,
+		MaxUses:   request.MaxUses,
+		CreatedBy: user.Id,
+	}
+	if request.Duration > 0 {
+		invite.Expires = time.Now().Add(time.Duration(request.Duration)*time.Second).UnixNano() / int64(time.Millisecond)
+	}
+	err = controller.InviteService.CreateInvite(&invite)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	logger.Logger.Infof("Invite %d to server %d created by %s", invite.Id, serverId, user.UserName)
+
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(&invite)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *InviteController) ListInvites(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	invites, err := controller.InviteService.ListInvites(serverId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(invites)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *InviteController) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	inviteId, err := parseIdParam(r, "inviteId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	invite := controller.InviteService.GetInvite(inviteId)
+	if invite == nil || invite.ServerId != serverId {
+		writeErrResponse(w, errors.New("can not find invite"))
+		return
+	}
+	err = controller.InviteService.RevokeInvite(invite.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *InviteController) RedeemInvite(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	invite, err := controller.InviteService.Redeem(user, mux.Vars(r)["code"])
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.RefreshPermissions(user.Id)
+
+	redemption := dto.InviteRedemption{
+		ServerId: invite.ServerId,
+		RoomId:   invite.RoomId,
+	}
+	if redemption.RoomId == 0 {
+		rooms, err := controller.ChatServerService.ListRooms(invite.ServerId)
+		if err == nil && len(rooms) > 0 {
+			redemption.RoomId = rooms[0].Id
+		}
+	}
+	err = json.NewEncoder(w).Encode(&redemption)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+type MemberController struct {
+	Session           *service.SessionService
+	ChatServerService *service.ChatServerService
+	BanService        *service.BanService
+	Permissions       *service.PermissionService
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+func (controller *MemberController) ListMembers(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	members, err := controller.ChatServerService.ListMembers(serverId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	result := make([]dto.ServerMember, 0, len(members))
+	for _, member := range members {
+		serverMember := dto.ServerMember{
+			UserId:      member.UserId,
+			Username:    member.User.UserName,
+			DisplayName: member.User.Name,
+			Nickname:    member.Nickname,
+			JoinedAt:    member.JoinedAt,
+		}
+		if role := controller.Permissions.RoleOf(member.UserId, serverId, 0); role != nil {
+			serverMember.RoleId = role.Id
+			serverMember.Role = role.Name
+		}
+		result = append(result, serverMember)
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+// JoinServer lets the user into a public server, other servers can only be joined by invite.
+func (controller *MemberController) JoinServer(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	server := controller.ChatServerService.GetServerById(serverId)
+	if server == nil {
+		writeErrResponse(w, errors.New("can not find server"))
+		return
+	}
+	if !server.Public {
+		writeForbidden(w)
+		return
+	}
+	if ban := controller.BanService.FindActiveBan(user.Id, &models.ChatRoom{ServerId: serverId}); ban != nil {
+		writeErrResponse(w, errors.New("user is banned from the server"))
+		return
+	}
+	err = controller.ChatServerService.AddMember(serverId, user.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.RefreshPermissions(user.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *MemberController) LeaveServer(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	role := controller.Permissions.RoleOf(user.Id, serverId, 0)
+	if role != nil && role.Id == models.RoleOwner {
+		writeErrResponse(w, errors.New("the owner can not leave the server"))
+		return
+	}
+	controller.removeMember(serverId, user.Id, websocket.CloseNormalClosure, "left server")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *MemberController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	userId, err := parseIdParam(r, "userId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	if !controller.ChatServerService.IsMember(serverId, userId) {
+		writeErrResponse(w, errors.New("user is not a member of the server"))
+		return
+	}
+	// moderators can only remove members holding less than themselves
+	if !controller.Permissions.CanActOn(user, userId, serverId, 0) {
+		writeForbidden(w)
+		return
+	}
+	controller.removeMember(serverId, userId, service.CloseKicked, "removed from server")
+	logger.Logger.Infof("User %d removed from server %d by %s", userId, serverId, user.UserName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *MemberController) removeMember(serverId int64, userId int64, code int, reason string) {
+	err := controller.ChatServerService.RemoveMember(serverId, userId)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+	controller.ConnectionManager.DisconnectUser(serverId, 0, userId, code, reason)
+}
+
+func (controller *MemberController) UpdateNickname(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	var request dto.NicknameUpdate
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	err = controller.ChatServerService.SetNickname(serverId, user.Id, request.Nickname)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
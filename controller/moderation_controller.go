@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+type ModerationController struct {
+	Session           *service.SessionService
+	UserService       *service.ChatUserService
+	ChatServerService *service.ChatServerService
+	BanService        *service.BanService
+	Permissions       *service.PermissionService
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+func writeForbidden(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = fmt.Fprint(w, "Permission denied")
+}
+
+func parseIdParam(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+}
+
+func (controller *ModerationController) KickUser(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+
+	var request dto.KickRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	if !controller.Permissions.CanActOn(user, request.UserId, room.ServerId, room.Id) {
+		writeForbidden(w)
+		return
+	}
+	closed := controller.ConnectionManager.DisconnectUser(room.ServerId, room.Id, request.UserId,
+		service.CloseKicked, request.Reason)
+	if closed == 0 {
+		writeErrResponse(w, errors.New("user is not connected to the room"))
+		return
+	}
+	logger.Logger.Infof("User %d kicked from room %d by %s", request.UserId, room.Id, user.UserName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *ModerationController) BanUser(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	var request dto.BanRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Duration < 0 {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	if request.RoomId != 0 {
+		room, err := controller.ChatServerService.GetRoom(request.RoomId)
+		if err != nil || room.ServerId != serverId {
+			writeErrResponse(w, errors.New("can not find room"))
+			return
+		}
+	}
+	if controller.UserService.GetUserById(request.UserId) == nil {
+		writeErrResponse(w, errors.New("can not find user"))
+		return
+	}
+	// a server ban also ends the membership, so it needs the same rank as removing the member
+	if !controller.Permissions.CanActOn(user, request.UserId, serverId, request.RoomId) {
+		writeForbidden(w)
+		return
+	}
+
+	ban := models.ChatBan{
+		UserId:    request.UserId,
+		ServerId:  serverId,
+		RoomId:    request.RoomId,
+		Reason:    request.Reason,
+		CreatedBy: user.Id,
+	}
+	if request.Duration > 0 {
+		ban.Expires = time.Now().Add(time.Duration(request.Duration)*time.Second).UnixNano() / int64(time.Millisecond)
+	}
+	err = controller.BanService.Ban(&ban)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.DisconnectUser(serverId, request.RoomId, request.UserId, service.CloseBanned, request.Reason)
+	if request.RoomId == 0 {
+		err = controller.ChatServerService.RemoveMember(serverId, request.UserId)
+		if err != nil {
+			logger.Logger.Error(err)
+		}
+	}
+	logger.Logger.Infof("User %d banned from server %d room %d by %s", ban.UserId, serverId, ban.RoomId, user.UserName)
+
+	err = json.NewEncoder(w).Encode(&ban)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *ModerationController) ListBans(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	bans, err := controller.BanService.ListBans(serverId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(bans)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *ModerationController) Unban(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	banId, err := parseIdParam(r, "banId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	ban := controller.BanService.GetBan(banId)
+	if ban == nil || ban.ServerId != serverId {
+		writeErrResponse(w, errors.New("can not find ban"))
+		return
+	}
+	err = controller.BanService.Unban(ban.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+type PermissionController struct {
+	Session           *service.SessionService
+	UserService       *service.ChatUserService
+	ChatServerService *service.ChatServerService
+	Permissions       *service.PermissionService
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+func (controller *PermissionController) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := controller.Permissions.ListRoles()
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(roles)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+// readAssignment decodes the requested role and makes sure the caller outranks the target and
+// does not grant permissions it does not hold itself.
+func (controller *PermissionController) readAssignment(w http.ResponseWriter, r *http.Request,
+	serverId int64, roomId int64) (*models.ChatUser, *models.ChatRole) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return nil, nil
+	}
+	userId, err := parseIdParam(r, "userId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return nil, nil
+	}
+	target := controller.UserService.GetUserById(userId)
+	if target == nil {
+		writeErrResponse(w, errors.New("can not find user"))
+		return nil, nil
+	}
+	if !controller.ChatServerService.IsMember(serverId, target.Id) {
+		writeErrResponse(w, errors.New("user is not a member of the server"))
+		return nil, nil
+	}
+	if !controller.Permissions.CanActOn(user, target.Id, serverId, roomId) {
+		writeForbidden(w)
+		return nil, nil
+	}
+	var request dto.RoleAssignment
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return nil, nil
+	}
+	role := controller.Permissions.GetRole(request.RoleId)
+	if role == nil {
+		writeErrResponse(w, errors.New("can not find role"))
+		return nil, nil
+	}
+	if role.Permissions&^controller.Permissions.Permissions(user, serverId, roomId) != 0 {
+		writeForbidden(w)
+		return nil, nil
+	}
+	return target, role
+}
+
+func (controller *PermissionController) AssignServerRole(w http.ResponseWriter, r *http.Request) {
+	serverId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	target, role := controller.readAssignment(w, r, serverId, 0)
+	if target == nil {
+		return
+	}
+	err = controller.Permissions.AssignServerRole(target.Id, serverId, role.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.RefreshPermissions(target.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *PermissionController) AssignRoomRole(w http.ResponseWriter, r *http.Request) {
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	target, role := controller.readAssignment(w, r, room.ServerId, room.Id)
+	if target == nil {
+		return
+	}
+	err = controller.Permissions.AssignRoomRole(target.Id, room.Id, role.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.RefreshPermissions(target.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *PermissionController) RemoveRoomRole(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	roomId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	userId, err := parseIdParam(r, "userId")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	room, err := controller.ChatServerService.GetRoom(roomId)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	if !controller.Permissions.CanActOn(user, userId, room.ServerId, room.Id) {
+		writeForbidden(w)
+		return
+	}
+	err = controller.Permissions.RemoveRoomRole(userId, room.Id)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	controller.ConnectionManager.RefreshPermissions(userId)
+	w.WriteHeader(http.StatusNoContent)
+}
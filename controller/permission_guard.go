@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+// PermissionScope tells which server, and optionally which room, a request operates on.
+type PermissionScope func(r *http.Request) (serverId int64, roomId int64, err error)
+
+type PermissionGuard struct {
+	Session           *service.SessionService
+	UserService       *service.ChatUserService
+	Permissions       *service.PermissionService
+	ChatServerService *service.ChatServerService
+}
+
+func (guard *PermissionGuard) ServerFromPath(name string) PermissionScope {
+	return func(r *http.Request) (int64, int64, error) {
+		serverId, err := parseIdParam(r, name)
+		if err != nil {
+			return 0, 0, err
+		}
+		if guard.ChatServerService.GetServerById(serverId) == nil {
+			return 0, 0, errors.New("can not find server")
+		}
+		return serverId, 0, nil
+	}
+}
+
+func (guard *PermissionGuard) RoomFromPath(name string) PermissionScope {
+	return func(r *http.Request) (int64, int64, error) {
+		roomId, err := parseIdParam(r, name)
+		if err != nil {
+			return 0, 0, err
+		}
+		room, err := guard.ChatServerService.GetRoom(roomId)
+		if err != nil {
+			return 0, 0, err
+		}
+		return room.ServerId, room.Id, nil
+	}
+}
+
+// Require only lets the request through when the caller holds the permission in its scope.
+func (guard *PermissionGuard) Require(permission models.Permission, scope PermissionScope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := guard.Session.GetUserFromRequest(w, r)
+		if user == nil {
+			return
+		}
+		serverId, roomId, err := scope(r)
+		if err != nil {
+			writeErrResponse(w, err)
+			return
+		}
+		if !guard.Permissions.HasPermission(user, serverId, roomId, permission) {
+			writeForbidden(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireAdministrator only lets the site administrator through.
+func (guard *PermissionGuard) RequireAdministrator(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := guard.Session.GetUserFromRequest(w, r)
+		if user == nil {
+			return
+		}
+		if !guard.UserService.IsAdministrator(user) {
+			writeForbidden(w)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"voice-chat-server/logger"
+	"voice-chat-server/service"
+)
+
+type StatsController struct {
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+// QueueStats lists the send queue of every connection by its id, a growing high watermark or
+// dropped frames point at clients that can not keep up.
+func (controller *StatsController) QueueStats(w http.ResponseWriter, r *http.Request) {
+	err := json.NewEncoder(w).Encode(controller.ConnectionManager.QueueStats())
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+)
+
+type UserController struct {
+	Session           *service.SessionService
+	UserService       *service.ChatUserService
+	ConnectionManager *service.ChatRoomConnectionManager
+}
+
+func decodePassword(encoded string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("error in request")
+	}
+	return string(decoded), nil
+}
+
+func writeUser(w http.ResponseWriter, user *models.ChatUser) {
+	err := json.NewEncoder(w).Encode(user)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *UserController) Register(w http.ResponseWriter, r *http.Request) {
+	var request dto.RegisterRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	password, err := decodePassword(request.Password)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	user, err := controller.UserService.Register(request.Username, password, request.Name, request.Invite)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeUser(w, user)
+}
+
+func (controller *UserController) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	writeUser(w, user)
+}
+
+func (controller *UserController) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	var request dto.ProfileUpdate
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	err = controller.UserService.UpdateProfile(user, request.Name)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	writeUser(w, user)
+}
+
+func (controller *UserController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	var request dto.PasswordChange
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	oldPwd, err := decodePassword(request.OldPassword)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	newPwd, err := decodePassword(request.NewPassword)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = controller.UserService.ChangePassword(user, oldPwd, newPwd)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *UserController) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := controller.Session.GetUserFromRequest(w, r)
+	if user == nil {
+		return
+	}
+	var request dto.AccountDeletion
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	password, err := decodePassword(request.Password)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	if controller.UserService.AuthUser(user.UserName, password) == nil {
+		writeErrResponse(w, errors.New("password is not correct"))
+		return
+	}
+	if controller.UserService.IsAdministrator(user) {
+		writeForbidden(w)
+		return
+	}
+	controller.ConnectionManager.DisconnectUser(0, 0, user.Id, websocket.CloseNormalClosure, "account deleted")
+	controller.ConnectionManager.CleanUserConnections(user.Id)
+	controller.Session.DeleteByUserName(user.UserName)
+	err = controller.UserService.DeleteUser(user)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	logger.Logger.Infof("User '%s' deleted their account", user.UserName)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (controller *UserController) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := controller.UserService.ListUsers()
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	err = json.NewEncoder(w).Encode(users)
+	if err != nil {
+		logger.Logger.Error("encode failed:", err)
+	}
+}
+
+func (controller *UserController) SetDisabled(w http.ResponseWriter, r *http.Request) {
+	userId, err := parseIdParam(r, "id")
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	target := controller.UserService.GetUserById(userId)
+	if target == nil {
+		writeErrResponse(w, errors.New("can not find user"))
+		return
+	}
+	var request dto.UserDisabled
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeErrResponse(w, errors.New("error in request"))
+		return
+	}
+	err = controller.UserService.SetDisabled(target, request.Disabled)
+	if err != nil {
+		writeErrResponse(w, err)
+		return
+	}
+	if request.Disabled {
+		controller.Session.DeleteByUserName(target.UserName)
+		controller.ConnectionManager.DisconnectUser(0, 0, target.Id, service.CloseAccountDisabled, "account disabled")
+	}
+	writeUser(w, target)
+}
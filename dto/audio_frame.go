@@ -0,0 +1,131 @@
+package dto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Binary websocket frames carry a single audio packet prefixed by a fixed header:
+//
+//	0       1       2               4                               8                              12
+//	+-------+-------+---------------+-------------------------------+-------------------------------+
+//	|version| flags |   sequence    |           timestamp           |           sender id           |
+//	+-------+-------+---------------+-------------------------------+-------------------------------+
+//
+// All multi-byte fields are big-endian. The lowest two flag bits select the payload codec.
+// When AudioFlagLevel is set, one byte holding the audio level in -dBov (0 loudest, 127 silence,
+// as in RFC 6464) follows the header. The payload comes last.
+const (
+	AudioFrameVersion    = 1
+	AudioFrameHeaderSize = 12
+	MaxAudioPayloadSize  = 4000
+	MixedSenderId        = 0
+	AudioLevelSilence    = 127
+)
+
+const (
+	AudioCodecOpus  uint8 = 0
+	AudioCodecPcm16 uint8 = 1
+
+	AudioFlagCodecMask uint8 = 0x03
+	AudioFlagLevel     uint8 = 0x04
+	audioFlagsKnown          = AudioFlagCodecMask | AudioFlagLevel
+)
+
+var (
+	ErrAudioFrameTooShort = errors.New("audio frame is shorter than its header")
+	ErrAudioFrameVersion  = errors.New("unsupported audio frame version")
+	ErrAudioFrameEmpty    = errors.New("audio frame has no payload")
+	ErrAudioFrameTooLarge = errors.New("audio frame payload is too large")
+	ErrAudioFrameBadFlags = errors.New("audio frame has unknown flags set")
+	ErrAudioFrameCodec    = errors.New("audio frame has an unknown codec")
+	ErrAudioFramePcm      = errors.New("pcm audio frame has an odd payload length")
+	ErrAudioFrameLevel    = errors.New("audio frame level is out of range")
+)
+
+type AudioFrame struct {
+	Version   uint8
+	Flags     uint8
+	Sequence  uint16
+	Timestamp uint32
+	SenderId  uint32
+	Level     uint8
+	Payload   []byte
+}
+
+func ParseAudioFrame(data []byte) (*AudioFrame, error) {
+	if len(data) < AudioFrameHeaderSize {
+		return nil, ErrAudioFrameTooShort
+	}
+	frame := AudioFrame{
+		Version:   data[0],
+		Flags:     data[1],
+		Sequence:  binary.BigEndian.Uint16(data[2:4]),
+		Timestamp: binary.BigEndian.Uint32(data[4:8]),
+		SenderId:  binary.BigEndian.Uint32(data[8:12]),
+		Payload:   data[AudioFrameHeaderSize:],
+	}
+	if frame.HasLevel() {
+		if len(frame.Payload) == 0 {
+			return nil, ErrAudioFrameTooShort
+		}
+		frame.Level = frame.Payload[0]
+		frame.Payload = frame.Payload[1:]
+	}
+	if err := frame.Validate(); err != nil {
+		return nil, err
+	}
+	return &frame, nil
+}
+
+func (frame *AudioFrame) Validate() error {
+	if frame.Version != AudioFrameVersion {
+		return ErrAudioFrameVersion
+	}
+	if frame.Flags&^audioFlagsKnown != 0 {
+		return ErrAudioFrameBadFlags
+	}
+	codec := frame.Codec()
+	if codec != AudioCodecOpus && codec != AudioCodecPcm16 {
+		return ErrAudioFrameCodec
+	}
+	if frame.HasLevel() && frame.Level > AudioLevelSilence {
+		return ErrAudioFrameLevel
+	}
+	if len(frame.Payload) == 0 {
+		return ErrAudioFrameEmpty
+	}
+	if len(frame.Payload) > MaxAudioPayloadSize {
+		return ErrAudioFrameTooLarge
+	}
+	if codec == AudioCodecPcm16 && len(frame.Payload)%2 != 0 {
+		return ErrAudioFramePcm
+	}
+	return nil
+}
+
+func (frame *AudioFrame) Codec() uint8 {
+	return frame.Flags & AudioFlagCodecMask
+}
+
+func (frame *AudioFrame) HasLevel() bool {
+	return frame.Flags&AudioFlagLevel != 0
+}
+
+func (frame *AudioFrame) Marshal() []byte {
+	headerSize := AudioFrameHeaderSize
+	if frame.HasLevel() {
+		headerSize++
+	}
+	data := make([]byte, headerSize+len(frame.Payload))
+	data[0] = frame.Version
+	data[1] = frame.Flags
+	binary.BigEndian.PutUint16(data[2:4], frame.Sequence)
+	binary.BigEndian.PutUint32(data[4:8], frame.Timestamp)
+	binary.BigEndian.PutUint32(data[8:12], frame.SenderId)
+	if frame.HasLevel() {
+		data[AudioFrameHeaderSize] = frame.Level
+	}
+	copy(data[headerSize:], frame.Payload)
+	return data
+}
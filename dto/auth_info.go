@@ -1,11 +1,19 @@
 package dto
 
 type AuthAuthority struct {
-	Id        int64  `json:"id"`
-	Authority string `json:"authority"`
+	Id          int64    `json:"id"`
+	Authority   string   `json:"authority"`
+	ServerId    int64    `json:"serverId"`
+	RoomId      int64    `json:"roomId,omitempty"`
+	Permissions []string `json:"permissions"`
 }
 
 type AuthInfo struct {
-	Username    string          `json:"username"`
-	Authorities []AuthAuthority `json:"authorities"`
+	Username      string          `json:"username"`
+	Administrator bool            `json:"administrator"`
+	Authorities   []AuthAuthority `json:"authorities"`
+}
+
+type RoleAssignment struct {
+	RoleId int64 `json:"roleId"`
 }
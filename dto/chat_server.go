@@ -0,0 +1,28 @@
+package dto
+
+type ServerRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Position    int    `json:"position"`
+	// Public servers can be joined without an invite.
+	Public bool `json:"public"`
+}
+
+type RoomRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// MediaMode is one of relay, sfu or mesh, empty uses the server default.
+	MediaMode string `json:"mediaMode"`
+	Mixing    bool   `json:"mixing"`
+	Position  int    `json:"position"`
+	// Capacity is the maximum number of participants and MaxSpeakers the maximum number of
+	// participants talking at once, zero means unlimited for both.
+	Capacity    int `json:"capacity"`
+	MaxSpeakers int `json:"maxSpeakers"`
+	// WaitingQueue lets users wait for a free slot instead of being rejected from a full room.
+	WaitingQueue bool `json:"waitingQueue"`
+	// Password is base64 encoded, nil keeps the current password and an empty one removes it.
+	Password *string `json:"password"`
+	// Private rooms only let in users granted access and those who manage rooms.
+	Private bool `json:"private"`
+}
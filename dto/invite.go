@@ -0,0 +1,18 @@
+package dto
+
+type InviteRequest struct {
+	// RoomId limits the invite to a single room, zero invites to the whole server.
+	RoomId int64 `json:"roomId"`
+	// RoleId is granted to users without a role on the server yet, zero grants the default role.
+	RoleId int64 `json:"roleId"`
+	// MaxUses of zero allows unlimited uses.
+	MaxUses int `json:"maxUses"`
+	// Duration of the invite in seconds, zero never expires.
+	Duration int64 `json:"duration"`
+}
+
+// InviteRedemption tells the client where to connect after redeeming an invite.
+type InviteRedemption struct {
+	ServerId int64 `json:"serverId"`
+	RoomId   int64 `json:"roomId"`
+}
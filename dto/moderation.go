@@ -0,0 +1,15 @@
+package dto
+
+type KickRequest struct {
+	UserId int64  `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+type BanRequest struct {
+	UserId int64 `json:"userId"`
+	// RoomId limits the ban to a single room, zero bans from the whole server.
+	RoomId int64 `json:"roomId"`
+	// Duration of the ban in seconds, zero bans permanently.
+	Duration int64  `json:"duration"`
+	Reason   string `json:"reason"`
+}
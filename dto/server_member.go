@@ -0,0 +1,15 @@
+package dto
+
+type ServerMember struct {
+	UserId      int64  `json:"userId"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	Nickname    string `json:"nickname,omitempty"`
+	JoinedAt    int64  `json:"joinedAt"`
+	RoleId      int64  `json:"roleId"`
+	Role        string `json:"role"`
+}
+
+type NicknameUpdate struct {
+	Nickname string `json:"nickname"`
+}
@@ -1,7 +1,190 @@
 package dto
 
-type Message struct {
-	From     string `from:"id"`
-	FromType string `fromType:"id"`
-	Message  string `message:"id"`
+import (
+	"encoding/json"
+	"time"
+)
+
+const ProtocolVersion = 1
+
+const (
+	MessageJoin      = "join"
+	MessageLeave     = "leave"
+	MessageMute      = "mute"
+	MessageUnmute    = "unmute"
+	MessageSpeaking  = "speaking"
+	MessageChat      = "chat"
+	MessagePing      = "ping"
+	MessagePong      = "pong"
+	MessageError     = "error"
+	MessageRoomState = "room_state"
+	MessageJoined    = "user_joined"
+	MessageLeft      = "user_left"
+	MessageOffer     = "offer"
+	MessageAnswer    = "answer"
+	MessageCandidate = "ice_candidate"
+	MessageMixing    = "mixing"
+
+	MessageDeafen        = "deafen"
+	MessageUndeafen      = "undeafen"
+	MessageForceMute     = "force_mute"
+	MessageForceUnmute   = "force_unmute"
+	MessageForceDeafen   = "force_deafen"
+	MessageForceUndeafen = "force_undeafen"
+	MessageVoiceState    = "voice_state"
+
+	MessageSpeakingStarted = "speaking_started"
+	MessageSpeakingStopped = "speaking_stopped"
+	MessageActiveSpeakers  = "active_speakers"
+
+	MessageQueued = "queued"
+)
+
+const (
+	ErrorBadRequest         = "bad_request"
+	ErrorForbidden          = "forbidden"
+	ErrorInternal           = "internal"
+	ErrorNegotiation        = "negotiation_failed"
+	ErrorRoomFull           = "room_full"
+	ErrorSpeakerLimit       = "speaker_limit"
+	ErrorTargetNotFound     = "target_not_found"
+	ErrorUnknownType        = "unknown_type"
+	ErrorUnsupported        = "unsupported"
+	ErrorUnsupportedVersion = "unsupported_version"
+)
+
+type Envelope struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Ts      int64           `json:"ts"`
+	From    *Sender         `json:"from,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func NewEnvelope(messageType string, id string, payload interface{}) (*Envelope, error) {
+	envelope := Envelope{
+		Version: ProtocolVersion,
+		Type:    messageType,
+		Id:      id,
+		Ts:      time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		envelope.Payload = data
+	}
+	return &envelope, nil
+}
+
+func (envelope *Envelope) DecodePayload(v interface{}) error {
+	if len(envelope.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(envelope.Payload, v)
+}
+
+type JoinPayload struct {
+	RoomId int64 `json:"roomId"`
+}
+
+type LeavePayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type VoiceState struct {
+	SelfMuted     bool `json:"selfMuted"`
+	SelfDeafened  bool `json:"selfDeafened"`
+	ForceMuted    bool `json:"forceMuted"`
+	ForceDeafened bool `json:"forceDeafened"`
+}
+
+func (state VoiceState) Muted() bool {
+	return state.SelfMuted || state.ForceMuted
+}
+
+func (state VoiceState) Deafened() bool {
+	return state.SelfDeafened || state.ForceDeafened
+}
+
+type ModerationPayload struct {
+	Target string `json:"target"`
+}
+
+type SpeakingPayload struct {
+	Speaking bool  `json:"speaking"`
+	Level    uint8 `json:"level,omitempty"`
+}
+
+type ActiveSpeaker struct {
+	ConnectionId string `json:"connectionId"`
+	UserId       int64  `json:"userId"`
+	Level        uint8  `json:"level"`
+}
+
+type ActiveSpeakersPayload struct {
+	Speakers []ActiveSpeaker `json:"speakers"`
+}
+
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+type PingPayload struct {
+	Nonce string `json:"nonce,omitempty"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Sender struct {
+	UserId       int64  `json:"userId"`
+	Username     string `json:"username"`
+	DisplayName  string `json:"displayName"`
+	ConnectionId string `json:"connectionId"`
+	Ssrc         uint32 `json:"ssrc"`
+}
+
+type RoomMember struct {
+	Sender
+	VoiceState VoiceState `json:"voiceState"`
+}
+
+// Capacity and MaxSpeakers are zero when unlimited.
+type RoomStatePayload struct {
+	RoomId      int64        `json:"roomId"`
+	MediaMode   string       `json:"mediaMode"`
+	Mixing      bool         `json:"mixing"`
+	Capacity    int          `json:"capacity,omitempty"`
+	MaxSpeakers int          `json:"maxSpeakers,omitempty"`
+	Members     []RoomMember `json:"members"`
+}
+
+// QueuePayload tells a connection waiting for a free slot where it stands in the queue.
+type QueuePayload struct {
+	RoomId   int64 `json:"roomId"`
+	Position int   `json:"position"`
+	Size     int   `json:"size"`
+}
+
+type SessionDescriptionPayload struct {
+	Sdp    string `json:"sdp"`
+	Target string `json:"target,omitempty"`
+}
+
+type IceCandidatePayload struct {
+	Candidate        string  `json:"candidate"`
+	SdpMid           *string `json:"sdpMid,omitempty"`
+	SdpMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+	Target           string  `json:"target,omitempty"`
+}
+
+type MixingPayload struct {
+	Enabled bool `json:"enabled"`
+	Room    bool `json:"room,omitempty"`
 }
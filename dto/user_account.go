@@ -0,0 +1,26 @@
+package dto
+
+// Passwords are base64 encoded, the same way as in UserCredentials.
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Invite   string `json:"invite"`
+}
+
+type ProfileUpdate struct {
+	Name string `json:"name"`
+}
+
+type PasswordChange struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
+
+type UserDisabled struct {
+	Disabled bool `json:"disabled"`
+}
@@ -12,9 +12,18 @@ var format = logging.MustStringFormatter(
 )
 
 func Init() {
+	initLevel(logging.DEBUG)
+}
+
+// InitQuiet only logs warnings and errors, for commands printing their own output.
+func InitQuiet() {
+	initLevel(logging.WARNING)
+}
+
+func initLevel(level logging.Level) {
 	console := logging.NewLogBackend(os.Stderr, "", 0)
 	consoleFormatter := logging.NewBackendFormatter(console, format)
 	consoleLeveled := logging.AddModuleLevel(consoleFormatter)
-	consoleLeveled.SetLevel(logging.DEBUG, "")
+	consoleLeveled.SetLevel(level, "")
 	logging.SetBackend(consoleLeveled)
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"voice-chat-server/config"
+	"voice-chat-server/logger"
+	"voice-chat-server/storage"
+)
+
+const migrateUsage = "usage: %s migrate up|down|status [flags]\n"
+
+// runMigrate applies, reverts or lists the schema migrations and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 2
+	}
+	action := args[0]
+	switch action {
+	case "up", "down", "status":
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, migrateUsage, os.Args[0])
+		return 2
+	}
+	cfg, err := config.Load(os.Args[0]+" migrate "+action, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	logger.Init()
+	s, err := openStore(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	switch action {
+	case "up":
+		var migrations []storage.Migration
+		migrations, err = s.MigrateUp()
+		for _, migration := range migrations {
+			fmt.Printf("applied %d: %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(migrations) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		var migration *storage.Migration
+		migration, err = s.MigrateDown()
+		if migration != nil {
+			fmt.Printf("reverted %d: %s\n", migration.Version, migration.Name)
+		}
+	case "status":
+		var status []storage.MigrationStatus
+		status, err = s.MigrationStatus()
+		for _, migration := range status {
+			applied := "pending"
+			if migration.AppliedAt != 0 {
+				applied = time.Unix(0, migration.AppliedAt*int64(time.Millisecond)).Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-26s  %s\n", migration.Version, applied, migration.Name)
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type ChatBan struct {
+	tableName struct{}    `pg:"chat_ban"`
+	Id        int64       `json:"id" pg:",pk"`
+	UserId    int64       `json:"userId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	User      *ChatUser   `json:"-"`
+	ServerId  int64       `json:"serverId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Server    *ChatServer `json:"-"`
+	RoomId    int64       `json:"roomId" pg:",use_zero"`
+	Reason    string      `json:"reason" pg:"type:varchar(255)"`
+	CreatedBy int64       `json:"createdBy" pg:"type:bigint"`
+	CreateAt  int64       `json:"createAt" pg:"type:bigint,notnull"`
+	Expires   int64       `json:"expires" pg:"type:bigint,use_zero"`
+}
+
+func (ban *ChatBan) IsExpired() bool {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return ban.Expires != 0 && ban.Expires < now
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type ChatInvite struct {
+	tableName struct{}    `pg:"chat_invite"`
+	Id        int64       `json:"id" pg:",pk"`
+	Code      string      `json:"code" pg:"type:varchar(32),unique,notnull"`
+	ServerId  int64       `json:"serverId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Server    *ChatServer `json:"-"`
+	RoomId    int64       `json:"roomId" pg:",use_zero"`
+	RoleId    int64       `json:"roleId" pg:",use_zero"`
+	MaxUses   int         `json:"maxUses" pg:",use_zero"`
+	Uses      int         `json:"uses" pg:",use_zero"`
+	CreatedBy int64       `json:"createdBy" pg:"type:bigint"`
+	CreateAt  int64       `json:"createAt" pg:"type:bigint,notnull"`
+	Expires   int64       `json:"expires" pg:"type:bigint,use_zero"`
+	Revoked   bool        `json:"revoked" pg:",use_zero"`
+}
+
+func (invite *ChatInvite) IsValid() bool {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if invite.Revoked || (invite.Expires != 0 && invite.Expires < now) {
+		return false
+	}
+	return invite.MaxUses == 0 || invite.Uses < invite.MaxUses
+}
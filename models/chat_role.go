@@ -0,0 +1,73 @@
+package models
+
+type Permission int64
+
+const (
+	PermissionConnect Permission = 1 << iota
+	PermissionSpeak
+	PermissionModerate
+	PermissionManageRooms
+	PermissionManageServer
+)
+
+var PermissionNames = []struct {
+	Permission Permission
+	Name       string
+}{
+	{PermissionConnect, "connect"},
+	{PermissionSpeak, "speak"},
+	{PermissionModerate, "moderate"},
+	{PermissionManageRooms, "manage_rooms"},
+	{PermissionManageServer, "manage_server"},
+}
+
+const (
+	RoleOwner     int64 = 1
+	RoleAdmin     int64 = 2
+	RoleModerator int64 = 3
+	RoleMember    int64 = 4
+	RoleGuest     int64 = 5
+)
+
+type ChatRole struct {
+	tableName   struct{}   `pg:"chat_role"`
+	Id          int64      `json:"id" pg:"type:bigint,unique,notnull,pk"`
+	Name        string     `json:"name" pg:"type:varchar(64),unique,notnull"`
+	Permissions Permission `json:"permissions" pg:"type:bigint,notnull,use_zero"`
+}
+
+func (role *ChatRole) Has(permission Permission) bool {
+	return role.Permissions&permission == permission
+}
+
+func (role *ChatRole) PermissionNames() []string {
+	names := make([]string, 0)
+	for _, p := range PermissionNames {
+		if role.Has(p.Permission) {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+type ChatServerRole struct {
+	tableName struct{} `pg:"chat_server_role"`
+	Id        int64    `pg:",pk"`
+	UserId    int64    `pg:"on_delete:CASCADE, on_update: CASCADE"`
+	User      *ChatUser
+	ServerId  int64 `pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Server    *ChatServer
+	RoleId    int64 `pg:"on_delete:RESTRICT, on_update: CASCADE"`
+	Role      *ChatRole
+}
+
+type ChatRoomRole struct {
+	tableName struct{} `pg:"chat_room_role"`
+	Id        int64    `pg:",pk"`
+	UserId    int64    `pg:"on_delete:CASCADE, on_update: CASCADE"`
+	User      *ChatUser
+	RoomId    int64 `pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Room      *ChatRoom
+	RoleId    int64 `pg:"on_delete:RESTRICT, on_update: CASCADE"`
+	Role      *ChatRole
+}
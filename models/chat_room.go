@@ -1,10 +1,31 @@
 package models
 
 type ChatRoom struct {
-	tableName   struct{} `pg:"chat_room"`
-	Id          int64    `json:"id" pg:"type:bigint,unique,notnull,pk"`
-	Name        string   `json:"name" pg:"type:varchar(255),notnull"`
-	Description string   `json:"description" pg:"type:varchar(255),notnull"`
-	ServerId    int64   `pg:"on_delete:RESTRICT, on_update: CASCADE"`
-	Server      *ChatServer
+	tableName    struct{} `pg:"chat_room"`
+	Id           int64    `json:"id" pg:"type:bigint,unique,notnull,pk"`
+	Name         string   `json:"name" pg:"type:varchar(255),notnull"`
+	Description  string   `json:"description" pg:"type:varchar(255),notnull"`
+	MediaMode    string   `json:"mediaMode" pg:"type:varchar(16)"`
+	Mixing       bool     `json:"mixing"`
+	Position     int      `json:"position" pg:",use_zero"`
+	Capacity     int      `json:"capacity" pg:",use_zero"`
+	MaxSpeakers  int      `json:"maxSpeakers" pg:",use_zero"`
+	WaitingQueue bool     `json:"waitingQueue"`
+	Password     string   `json:"-" pg:"type:varchar(255)"`
+	Locked       bool     `json:"locked" pg:"-"`
+	Private      bool     `json:"private"`
+	ServerId     int64    `pg:"on_delete:RESTRICT, on_update: CASCADE"`
+	Server       *ChatServer
+}
+
+// ChatRoomAccess lets a user into a private room.
+type ChatRoomAccess struct {
+	tableName struct{}  `pg:"chat_room_access"`
+	Id        int64     `json:"-" pg:",pk"`
+	RoomId    int64     `json:"roomId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Room      *ChatRoom `json:"-"`
+	UserId    int64     `json:"userId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	User      *ChatUser `json:"-"`
+	CreatedBy int64     `json:"createdBy" pg:"type:bigint"`
+	CreateAt  int64     `json:"createAt" pg:"type:bigint,notnull"`
 }
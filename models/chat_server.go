@@ -5,10 +5,14 @@ type ChatServer struct {
 	Id          int64   `pg:"type:bigint,unique,notnull,pk"`
 	Name        string   `pg:"type:varchar(255),notnull"`
 	Description string   `pg:"type:varchar(255),notnull"`
+	Position    int      `pg:",use_zero"`
+	Public      bool     `pg:",use_zero"`
 }
 
 type ChatServerData struct {
 	Id          int64 `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Position    int    `json:"position"`
+	Public      bool   `json:"public"`
 }
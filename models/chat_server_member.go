@@ -0,0 +1,12 @@
+package models
+
+type ChatServerMember struct {
+	tableName struct{}    `pg:"chat_server_member"`
+	Id        int64       `json:"-" pg:",pk"`
+	ServerId  int64       `json:"serverId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	Server    *ChatServer `json:"-"`
+	UserId    int64       `json:"userId" pg:"on_delete:CASCADE, on_update: CASCADE"`
+	User      *ChatUser   `json:"-"`
+	Nickname  string      `json:"nickname" pg:"type:varchar(64)"`
+	JoinedAt  int64       `json:"joinedAt" pg:"type:bigint,notnull"`
+}
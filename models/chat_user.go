@@ -5,5 +5,6 @@ type ChatUser struct {
 	Id        int64    `json:"id" pg:"type:bigint,unique,notnull,pk"`
 	Name      string   `json:"name" pg:"type:varchar(255),notnull"`
 	UserName  string   `json:"username" pg:"type:varchar(255),unique,notnull"`
-	Password  string   `json:"password" pg:"type:varchar(255),notnull"`
+	Password  string   `json:"-" pg:"type:varchar(255),notnull"`
+	Disabled  bool     `json:"disabled" pg:",use_zero"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RefreshToken is stored by the hash of its value. Every refresh uses the token up and issues
+// a new one of the same family, so a token used twice reveals that it was stolen.
+type RefreshToken struct {
+	tableName struct{} `pg:"chat_refresh_token"`
+	Id        int64    `pg:",pk"`
+	UserName  string   `pg:"type:varchar(255),notnull"`
+	Family    string   `pg:"type:varchar(64),notnull"`
+	TokenHash string   `pg:"type:varchar(64),unique,notnull"`
+	CreateAt  int64    `pg:"type:bigint,notnull"`
+	Expires   int64    `pg:"type:bigint,notnull"`
+	Used      bool     `pg:",use_zero"`
+	Revoked   bool     `pg:",use_zero"`
+}
+
+func (token *RefreshToken) IsExpired() bool {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	return token.Expires < now
+}
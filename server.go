@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"github.com/go-pg/pg/v9"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"net/http"
@@ -11,49 +11,123 @@ import (
 	"os/signal"
 	"strings"
 	"time"
+	"voice-chat-server/config"
 	"voice-chat-server/controller"
+	"voice-chat-server/dto"
 	"voice-chat-server/logger"
+	"voice-chat-server/models"
 	"voice-chat-server/service"
+	"voice-chat-server/storage"
+	"voice-chat-server/utils/auth"
 )
 
-var dbService = service.DBService{}
+var store storage.Store
 var sessionService = service.SessionService{
 	UserService: &chatUserService,
-	DbService:   &dbService,
-}
-var chatServerService = service.ChatServerService{
-	DbService: &dbService,
 }
+var chatServerService = service.ChatServerService{}
 var chatUserService = service.ChatUserService{
-	DbService: &dbService,
+	RegistrationMode: service.RegistrationOpen,
+}
+var userController = controller.UserController{
+	Session:           &sessionService,
+	UserService:       &chatUserService,
+	ConnectionManager: &connectionManager,
 }
 var authController = controller.AuthController{
 	UserService: &chatUserService,
 	Session:     &sessionService,
+	Permissions: &permissionService,
 }
 var chatServerController = controller.ChatServerController{
+	Session:           &sessionService,
+	UserService:       &chatUserService,
+	ChatServerService: &chatServerService,
+	Permissions:       &permissionService,
+	ConnectionManager: &connectionManager,
+}
+var banService = service.BanService{}
+var moderationController = controller.ModerationController{
+	Session:           &sessionService,
+	UserService:       &chatUserService,
+	ChatServerService: &chatServerService,
+	BanService:        &banService,
+	Permissions:       &permissionService,
+	ConnectionManager: &connectionManager,
+}
+var permissionService = service.PermissionService{
+	UserService:       &chatUserService,
+	ChatServerService: &chatServerService,
+	DefaultRoleId:     models.RoleMember,
+}
+var memberController = controller.MemberController{
+	Session:           &sessionService,
+	ChatServerService: &chatServerService,
+	BanService:        &banService,
+	Permissions:       &permissionService,
+	ConnectionManager: &connectionManager,
+}
+var inviteService = service.InviteService{
+	ChatServerService: &chatServerService,
+	BanService:        &banService,
+	Permissions:       &permissionService,
+}
+var inviteController = controller.InviteController{
+	Session:           &sessionService,
+	ChatServerService: &chatServerService,
+	Permissions:       &permissionService,
+	InviteService:     &inviteService,
+	ConnectionManager: &connectionManager,
+}
+var permissionGuard = controller.PermissionGuard{
+	Session:           &sessionService,
+	UserService:       &chatUserService,
+	Permissions:       &permissionService,
+	ChatServerService: &chatServerService,
+}
+var permissionController = controller.PermissionController{
+	Session:           &sessionService,
+	UserService:       &chatUserService,
 	ChatServerService: &chatServerService,
+	Permissions:       &permissionService,
+	ConnectionManager: &connectionManager,
+}
+var statsController = controller.StatsController{
+	ConnectionManager: &connectionManager,
 }
 var connectionManager = service.ChatRoomConnectionManager{
 	ChatServerService: &chatServerService,
+	BanService:        &banService,
+	Permissions:       &permissionService,
 	Session:           &sessionService,
-	DbService:         &dbService,
 	Upgrader:          &websocket.Upgrader{},
+	SendQueueSize:     service.DefaultSendQueueSize,
+	OverflowPolicy:    service.OverflowDropOldest,
+	MaxDroppedFrames:  service.DefaultMaxDroppedFrames,
+	DefaultMediaMode:  service.MediaModeRelay,
+	MixerOutputCodec:  dto.AudioCodecPcm16,
+	VoiceActivity:     service.DefaultVoiceActivityOptions,
 }
 
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Do stuff here
-		logger.Logger.Debug("Current req:", r.RequestURI)
+		// only the path, the query can carry the room password and the access token
+		logger.Logger.Debug("Current req:", r.URL.Path)
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		next.ServeHTTP(w, r)
 	})
 }
 
-var validateUrls = [...]string{"/api/server", "/api/auth/info"}
+var validateUrls = [...]string{"/api/server", "/api/auth/info", "/api/auth/logout", "/api/roles", "/api/users", "/api/invites", "/api/stats"}
 
 func validateTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// registration is the only public endpoint under /api/users
+		if r.Method == http.MethodPost && r.URL.Path == "/api/users" {
+			next.ServeHTTP(w, r)
+			return
+		}
 		matched := false
 		for _, url := range validateUrls {
 			if strings.HasPrefix(r.RequestURI, url) {
@@ -75,56 +149,241 @@ func validateTokenMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func doInit() {
-	logger.Init()
+// openStore connects the storage backend selected by the config.
+func openStore(cfg *config.Config) (storage.Store, error) {
+	driver, source := cfg.Database.Backend()
+	switch driver {
+	case storage.DriverMemory:
+		logger.Logger.Warning("Using the memory store, nothing is kept across restarts")
+		return storage.NewMemoryStore(), nil
+	case storage.DriverSqlite:
+		sqlite := &storage.SqliteStore{Path: source}
+		return sqlite, sqlite.Open()
+	}
+	postgres := &storage.PostgresStore{
+		URL:      source,
+		Addr:     cfg.Database.Addr,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		Database: cfg.Database.Name,
+	}
+	return postgres, postgres.Connect()
+}
+
+// useStore hands the repositories of the store to the services.
+func useStore(s storage.Store) {
+	store = s
+	sessionService.Sessions = s
+	chatUserService.Users = s
+	chatServerService.Servers = s
+	chatServerService.Rooms = s
+	permissionService.Roles = s
+	banService.Bans = s
+	inviteService.Invites = s
+	connectionManager.ConnStats = s
+}
+
+// applyConfig injects the loaded config into the services before they are initialized.
+func applyConfig(cfg *config.Config) {
+	auth.SecretKey = cfg.Auth.SecretKey
+	sessionService.Expiration = int64(cfg.Auth.TokenExpiration / time.Millisecond)
+	sessionService.RefreshExpiration = int64(cfg.Auth.RefreshExpiration / time.Millisecond)
+	chatUserService.RegistrationMode = cfg.Auth.Registration
+	connectionManager.SendQueueSize = cfg.Voice.SendQueueSize
+	connectionManager.MaxDroppedFrames = cfg.Voice.MaxDroppedFrames
+	connectionManager.OverflowPolicy = service.OverflowDropOldest
+	if cfg.Voice.OverflowPolicy == "disconnect" {
+		connectionManager.OverflowPolicy = service.OverflowDisconnect
+	}
+	connectionManager.VoiceActivity = voiceActivityOptions(cfg.Voice)
+}
+
+func voiceActivityOptions(voice config.VoiceConfig) service.VoiceActivityOptions {
+	return service.VoiceActivityOptions{
+		StartLevel:     uint8(voice.StartLevel),
+		StopLevel:      uint8(voice.StopLevel),
+		StartFrames:    voice.StartFrames,
+		Hangover:       voice.Hangover,
+		ReportInterval: voice.ActiveSpeakersInterval,
+	}
+}
 
-	// init in transaction
-	err := dbService.Connect()
+func doInit(cfg *config.Config) {
+	logger.Init()
+	if cfg.Path != "" {
+		logger.Logger.Infof("Config loaded from %s", cfg.Path)
+	}
+	if cfg.Auth.SecretKey == config.DefaultSecretKey {
+		logger.Logger.Warning("Using the default secret key, set auth.secretKey in production")
+	}
+	err := openServices(cfg)
 	if err != nil {
 		logger.Logger.Fatal(err)
 	}
-	err = dbService.DB.RunInTransaction(func(tx *pg.Tx) error {
-		err = sessionService.Init()
-		if err != nil {
-			return err
-		}
-		err = chatUserService.Init()
-		if err != nil {
-			return err
-		}
-		err = chatServerService.Init()
-		if err != nil {
-			return err
-		}
-		err = connectionManager.Init()
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	connectionManager.Sfu, err = service.NewSelectiveForwardingUnit(nil)
+	if err != nil {
+		logger.Logger.Fatal(err)
+	}
+	err = sessionService.Init()
+	if err != nil {
+		logger.Logger.Fatal(err)
+	}
+	err = connectionManager.Init()
 	if err != nil {
 		logger.Logger.Fatal(err)
 	}
 }
 
-func main() {
-	doInit()
+// openServices opens and migrates the store of the config and initializes the services the
+// server shares with the administration commands, creating the defaults on a new database.
+func openServices(cfg *config.Config) error {
+	applyConfig(cfg)
+
+	s, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	useStore(s)
+	migrations, err := store.MigrateUp()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		logger.Logger.Infof("Applied migration %d: %s", migration.Version, migration.Name)
+	}
+	// set here as the invite service depends on the user service through the permissions
+	chatUserService.Invites = &inviteService
+	return initServices()
+}
+
+func initServices() error {
+	err := chatUserService.Init()
+	if err != nil {
+		return err
+	}
+	err = chatServerService.Init()
+	if err != nil {
+		return err
+	}
+	err = permissionService.Init()
+	if err != nil {
+		return err
+	}
+	err = banService.Init()
+	if err != nil {
+		return err
+	}
+	err = inviteService.Init()
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
+// newRouter routes the api and the websocket endpoint to the controllers.
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/ws/connect", connectionManager.Connect)
 	r.HandleFunc("/api/auth/login", authController.DoLogin).Methods("POST")
+	r.HandleFunc("/api/auth/refresh", authController.RefreshToken).Methods("POST")
+	r.HandleFunc("/api/auth/logout", authController.Logout).Methods("POST")
 	r.HandleFunc("/api/auth/info", authController.GetAuthInfo).Methods("GET")
 	r.HandleFunc("/api/server/list", chatServerController.ListServers).Methods("GET")
 	r.HandleFunc("/api/server/info/{id}", chatServerController.GetServerInfo).Methods("GET")
 	r.HandleFunc("/api/server/room", chatServerController.ListRooms).Methods("GET")
+	r.HandleFunc("/api/server", permissionGuard.RequireAdministrator(chatServerController.CreateServer)).Methods("POST")
+	r.HandleFunc("/api/server/room/{id}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), chatServerController.UpdateRoom)).Methods("PUT")
+	r.HandleFunc("/api/server/room/{id}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), chatServerController.DeleteRoom)).Methods("DELETE")
+	r.HandleFunc("/api/server/room/{id}/members", permissionGuard.Require(models.PermissionConnect,
+		permissionGuard.RoomFromPath("id"), chatServerController.ListRoomMembers)).Methods("GET")
+	r.HandleFunc("/api/server/room/{id}/access", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), chatServerController.ListRoomAccess)).Methods("GET")
+	r.HandleFunc("/api/server/room/{id}/access/{userId}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), chatServerController.GrantRoomAccess)).Methods("PUT")
+	r.HandleFunc("/api/server/room/{id}/access/{userId}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), chatServerController.RevokeRoomAccess)).Methods("DELETE")
+	r.HandleFunc("/api/server/room/{id}/kick", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.RoomFromPath("id"), moderationController.KickUser)).Methods("POST")
+	r.HandleFunc("/api/server/room/{id}/roles/{userId}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), permissionController.AssignRoomRole)).Methods("PUT")
+	r.HandleFunc("/api/server/room/{id}/roles/{userId}", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.RoomFromPath("id"), permissionController.RemoveRoomRole)).Methods("DELETE")
+	r.HandleFunc("/api/server/{id}", permissionGuard.Require(models.PermissionManageServer,
+		permissionGuard.ServerFromPath("id"), chatServerController.UpdateServer)).Methods("PUT")
+	r.HandleFunc("/api/server/{id}", permissionGuard.Require(models.PermissionManageServer,
+		permissionGuard.ServerFromPath("id"), chatServerController.DeleteServer)).Methods("DELETE")
+	r.HandleFunc("/api/server/{id}/rooms", permissionGuard.Require(models.PermissionManageRooms,
+		permissionGuard.ServerFromPath("id"), chatServerController.CreateRoom)).Methods("POST")
+	r.HandleFunc("/api/server/{id}/bans", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), moderationController.ListBans)).Methods("GET")
+	r.HandleFunc("/api/server/{id}/bans", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), moderationController.BanUser)).Methods("POST")
+	r.HandleFunc("/api/server/{id}/bans/{banId}", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), moderationController.Unban)).Methods("DELETE")
+	r.HandleFunc("/api/server/{id}/join", memberController.JoinServer).Methods("POST")
+	r.HandleFunc("/api/server/{id}/leave", memberController.LeaveServer).Methods("POST")
+	r.HandleFunc("/api/server/{id}/members", permissionGuard.Require(models.PermissionConnect,
+		permissionGuard.ServerFromPath("id"), memberController.ListMembers)).Methods("GET")
+	r.HandleFunc("/api/server/{id}/members/me", memberController.UpdateNickname).Methods("PUT")
+	r.HandleFunc("/api/server/{id}/members/{userId}", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), memberController.RemoveMember)).Methods("DELETE")
+	r.HandleFunc("/api/server/{id}/invites", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), inviteController.ListInvites)).Methods("GET")
+	r.HandleFunc("/api/server/{id}/invites", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), inviteController.CreateInvite)).Methods("POST")
+	r.HandleFunc("/api/server/{id}/invites/{inviteId}", permissionGuard.Require(models.PermissionModerate,
+		permissionGuard.ServerFromPath("id"), inviteController.RevokeInvite)).Methods("DELETE")
+	r.HandleFunc("/api/server/{id}/roles/{userId}", permissionGuard.Require(models.PermissionManageServer,
+		permissionGuard.ServerFromPath("id"), permissionController.AssignServerRole)).Methods("PUT")
+	r.HandleFunc("/api/roles", permissionController.ListRoles).Methods("GET")
+	r.HandleFunc("/api/invites/{code}", inviteController.RedeemInvite).Methods("POST")
+	r.HandleFunc("/api/users", userController.Register).Methods("POST")
+	r.HandleFunc("/api/users", permissionGuard.RequireAdministrator(userController.ListUsers)).Methods("GET")
+	r.HandleFunc("/api/users/me", userController.GetCurrentUser).Methods("GET")
+	r.HandleFunc("/api/users/me", userController.UpdateProfile).Methods("PUT")
+	r.HandleFunc("/api/users/me", userController.DeleteAccount).Methods("DELETE")
+	r.HandleFunc("/api/users/me/password", userController.ChangePassword).Methods("PUT")
+	r.HandleFunc("/api/users/{id}/disabled", permissionGuard.RequireAdministrator(userController.SetDisabled)).Methods("PUT")
+	r.HandleFunc("/api/stats/queues", permissionGuard.RequireAdministrator(statsController.QueueStats)).Methods("GET")
 	r.Use(loggingMiddleware, validateTokenMiddleware)
+	return r
+}
+
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the server until it is interrupted.
+func serve(args []string) int {
+	cfg, err := config.Load(os.Args[0]+" serve", args)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cfg.PrintConfig {
+		out, err := cfg.Redacted().Yaml()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Print(out)
+		return 0
+	}
+	doInit(cfg)
+
+	r := newRouter()
 
-	logger.Logger.Info("Server start at: localhost:8080")
+	logger.Logger.Infof("Server start at: %s", cfg.Server.Addr)
 	srv := http.Server{
-		Addr:         "0.0.0.0:8080",
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		Addr:         cfg.Server.Addr,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 		Handler:      r,
 	}
 	go func() {
@@ -138,17 +397,18 @@ func main() {
 
 	<-c
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 
 	defer func() {
 		sessionService.Close()
-		dbService.CloseConnection()
+		_ = store.Close()
 		cancel()
 	}()
 
-	err := srv.Shutdown(ctx)
+	err = srv.Shutdown(ctx)
 	if err != nil {
 		logger.Logger.Error(err)
 	}
 	logger.Logger.Info("Server shut down")
+	return 0
 }
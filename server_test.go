@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"voice-chat-server/config"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/service"
+	"voice-chat-server/storage"
+)
+
+var setupOnce sync.Once
+
+// setupServer wires the services to a memory store once, the services are package variables
+// shared by every test.
+func setupServer(t *testing.T) *httptest.Server {
+	setupOnce.Do(func() {
+		logger.InitQuiet()
+		useStore(storage.NewMemoryStore())
+		chatUserService.Invites = &inviteService
+		if err := initServices(); err != nil {
+			t.Fatal(err)
+		}
+		if err := connectionManager.Init(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	server := httptest.NewServer(newRouter())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func loginAs(t *testing.T, username string) string {
+	user := chatUserService.GetUserByUsername(username)
+	if user == nil {
+		t.Fatalf("user %s not found", username)
+	}
+	token, err := sessionService.Login(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.Token
+}
+
+func createUser(t *testing.T, username string) *models.ChatUser {
+	user, err := chatUserService.CreateUser(username, "secret-password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = chatServerService.AddMember(1, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// request sends the body encoded as json with the token of the user.
+func request(t *testing.T, server *httptest.Server, method string, path string, token string, body interface{}) *http.Response {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp
+}
+
+func connectRoom(t *testing.T, server *httptest.Server, token string, roomId string) (*websocket.Conn, *http.Response, error) {
+	query := url.Values{"room": {roomId}, "Authorization": {token}}
+	address := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/connect?" + query.Encode()
+	return websocket.DefaultDialer.Dial(address, nil)
+}
+
+func readEnvelope(t *testing.T, conn *websocket.Conn) dto.Envelope {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg dto.Envelope
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// readUntil skips the messages of other types.
+func readUntil(t *testing.T, conn *websocket.Conn, messageType string) dto.Envelope {
+	for {
+		if msg := readEnvelope(t, conn); msg.Type == messageType {
+			return msg
+		}
+	}
+}
+
+func joinRoom(t *testing.T, server *httptest.Server, username string) *websocket.Conn {
+	conn, _, err := connectRoom(t, server, loginAs(t, username), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	readUntil(t, conn, dto.MessageRoomState)
+	return conn
+}
+
+func TestConnectToRoom(t *testing.T) {
+	server := setupServer(t)
+
+	conn, _, err := connectRoom(t, server, loginAs(t, service.DefaultAdminUsername), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if msg := readEnvelope(t, conn); msg.Type != dto.MessageRoomState {
+		t.Fatalf("expected %s, got %s", dto.MessageRoomState, msg.Type)
+	}
+
+	createUser(t, "connect-member")
+	other, _, err := connectRoom(t, server, loginAs(t, "connect-member"), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if msg := readEnvelope(t, other); msg.Type != dto.MessageRoomState {
+		t.Fatalf("expected %s, got %s", dto.MessageRoomState, msg.Type)
+	}
+	msg := readEnvelope(t, conn)
+	if msg.Type != dto.MessageJoined {
+		t.Fatalf("expected %s, got %s", dto.MessageJoined, msg.Type)
+	}
+	var member dto.RoomMember
+	if err = json.Unmarshal(msg.Payload, &member); err != nil {
+		t.Fatal(err)
+	}
+	if member.Username != "connect-member" {
+		t.Fatalf("expected connect-member to join, got %s", member.Username)
+	}
+}
+
+func TestConnectWithoutToken(t *testing.T) {
+	server := setupServer(t)
+
+	_, resp, err := connectRoom(t, server, "", "1")
+	if err == nil {
+		t.Fatal("expected the connection to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %v", http.StatusUnauthorized, resp)
+	}
+}
+
+func TestModeratorCanOnlyBanLowerRanks(t *testing.T) {
+	server := setupServer(t)
+
+	moderator := createUser(t, "ban-moderator")
+	peer := createUser(t, "ban-peer")
+	owner := createUser(t, "ban-owner")
+	member := createUser(t, "ban-member")
+	roles := map[int64]int64{moderator.Id: models.RoleModerator, peer.Id: models.RoleModerator, owner.Id: models.RoleOwner}
+	for userId, roleId := range roles {
+		if err := permissionService.AssignServerRole(userId, 1, roleId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token := loginAs(t, moderator.UserName)
+
+	admin := chatUserService.GetUserByUsername(service.DefaultAdminUsername)
+	for _, target := range []*models.ChatUser{admin, owner, peer} {
+		resp := request(t, server, http.MethodPost, "/api/server/1/bans", token, dto.BanRequest{UserId: target.Id})
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("ban of %s: expected %d, got %d", target.UserName, http.StatusForbidden, resp.StatusCode)
+		}
+		if !chatServerService.IsMember(1, target.Id) {
+			t.Fatalf("%s lost the membership", target.UserName)
+		}
+	}
+	for _, target := range []*models.ChatUser{admin, peer} {
+		resp := request(t, server, http.MethodPost, "/api/server/room/1/kick", token, dto.KickRequest{UserId: target.Id})
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("kick of %s: expected %d, got %d", target.UserName, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+
+	resp := request(t, server, http.MethodPost, "/api/server/1/bans", token, dto.BanRequest{UserId: member.Id})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("ban of member: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if chatServerService.IsMember(1, member.Id) {
+		t.Fatal("banned member is still a member")
+	}
+}
+
+func TestModeratorCanOnlyMuteLowerRanks(t *testing.T) {
+	server := setupServer(t)
+
+	moderator := createUser(t, "mute-moderator")
+	peer := createUser(t, "mute-peer")
+	for _, user := range []*models.ChatUser{moderator, peer} {
+		if err := permissionService.AssignServerRole(user.Id, 1, models.RoleModerator); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createUser(t, "mute-member")
+	conn := joinRoom(t, server, moderator.UserName)
+
+	forbidden := map[string]bool{service.DefaultAdminUsername: true, peer.UserName: true}
+	for _, username := range []string{service.DefaultAdminUsername, peer.UserName, "mute-member"} {
+		joinRoom(t, server, username)
+		var target dto.RoomMember
+		if err := json.Unmarshal(readUntil(t, conn, dto.MessageJoined).Payload, &target); err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(dto.ModerationPayload{Target: target.ConnectionId})
+		err := conn.WriteJSON(dto.Envelope{Version: dto.ProtocolVersion, Type: dto.MessageForceMute, Id: username, Payload: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := readEnvelope(t, conn)
+		for msg.Id != username && msg.Type != dto.MessageVoiceState {
+			msg = readEnvelope(t, conn)
+		}
+		if forbidden[username] {
+			var errPayload dto.ErrorPayload
+			_ = json.Unmarshal(msg.Payload, &errPayload)
+			if msg.Type != dto.MessageError || errPayload.Code != dto.ErrorForbidden {
+				t.Fatalf("mute of %s: expected %s error, got %s %s", username, dto.ErrorForbidden, msg.Type, msg.Payload)
+			}
+		} else if msg.Type != dto.MessageVoiceState {
+			t.Fatalf("mute of member: expected %s, got %s %s", dto.MessageVoiceState, msg.Type, msg.Payload)
+		}
+	}
+}
+
+func TestAdminCanNotDemoteTheOwner(t *testing.T) {
+	server := setupServer(t)
+
+	admin := createUser(t, "demote-admin")
+	owner := createUser(t, "demote-owner")
+	moderator := createUser(t, "demote-moderator")
+	roles := map[int64]int64{admin.Id: models.RoleAdmin, owner.Id: models.RoleOwner, moderator.Id: models.RoleModerator}
+	for userId, roleId := range roles {
+		if err := permissionService.AssignServerRole(userId, 1, roleId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token := loginAs(t, admin.UserName)
+
+	demote := dto.RoleAssignment{RoleId: models.RoleMember}
+	ownerId := strconv.FormatInt(owner.Id, 10)
+	for _, path := range []string{"/api/server/1/roles/" + ownerId, "/api/server/room/1/roles/" + ownerId} {
+		resp := request(t, server, http.MethodPut, path, token, demote)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("PUT %s: expected %d, got %d", path, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+	path := "/api/server/room/1/roles/" + ownerId
+	if resp := request(t, server, http.MethodDelete, path, token, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("DELETE %s: expected %d, got %d", path, http.StatusForbidden, resp.StatusCode)
+	}
+	if role := permissionService.RoleOf(owner.Id, 1, 1); role == nil || role.Id != models.RoleOwner {
+		t.Fatalf("owner was demoted to %+v", role)
+	}
+
+	// lower ranks can still be demoted in a room
+	path = "/api/server/room/1/roles/" + strconv.FormatInt(moderator.Id, 10)
+	if resp := request(t, server, http.MethodPut, path, token, demote); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT %s: expected %d, got %d", path, http.StatusNoContent, resp.StatusCode)
+	}
+	if role := permissionService.RoleOf(moderator.Id, 1, 1); role == nil || role.Id != models.RoleMember {
+		t.Fatalf("moderator was not demoted, has %+v", role)
+	}
+}
+
+func TestDeleteRoomClosesConnections(t *testing.T) {
+	server := setupServer(t)
+
+	room := models.ChatRoom{Name: "deleted", Description: "deleted", ServerId: 1}
+	if err := chatServerService.CreateRoom(&room); err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, "delete-member")
+	roomId := strconv.FormatInt(room.Id, 10)
+	conn, _, err := connectRoom(t, server, loginAs(t, "delete-member"), roomId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	readUntil(t, conn, dto.MessageRoomState)
+
+	resp := request(t, server, http.MethodDelete, "/api/server/room/"+roomId, loginAs(t, service.DefaultAdminUsername), nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, service.CloseRoomDeleted) {
+		t.Fatalf("expected close %d, got %v", service.CloseRoomDeleted, err)
+	}
+	if chatServerService.GetRoomById(room.Id) != nil {
+		t.Fatal("room was not deleted")
+	}
+	if _, _, err = connectRoom(t, server, loginAs(t, "delete-member"), roomId); err == nil {
+		t.Fatal("connected to the deleted room")
+	}
+}
+
+func TestServerIsOnlyVisibleToMembers(t *testing.T) {
+	server := setupServer(t)
+
+	outsider, err := chatUserService.CreateUser("visible-outsider", "secret-password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, "visible-member")
+	for _, path := range []string{"/api/server/info/1", "/api/server/room?id=1"} {
+		expected := map[string]int{
+			outsider.UserName:            http.StatusForbidden,
+			"visible-member":             http.StatusOK,
+			service.DefaultAdminUsername: http.StatusOK,
+		}
+		for username, status := range expected {
+			resp := request(t, server, http.MethodGet, path, loginAs(t, username), nil)
+			if resp.StatusCode != status {
+				t.Fatalf("%s of %s: expected %d, got %d", path, username, status, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestPrivateRoomMembers(t *testing.T) {
+	server := setupServer(t)
+
+	room := models.ChatRoom{Name: "private", Description: "private", ServerId: 1, Private: true}
+	if err := chatServerService.CreateRoom(&room); err != nil {
+		t.Fatal(err)
+	}
+	member := createUser(t, "private-member")
+	path := "/api/server/room/" + strconv.FormatInt(room.Id, 10) + "/members"
+
+	resp := request(t, server, http.MethodGet, path, loginAs(t, member.UserName), nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("without access: expected %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+	resp = request(t, server, http.MethodGet, path, loginAs(t, service.DefaultAdminUsername), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("room manager: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if err := chatServerService.GrantRoomAccess(room.Id, member.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(t, server, http.MethodGet, path, loginAs(t, member.UserName), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with access: expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	server := setupServer(t)
+
+	member := createUser(t, "stats-member")
+	joinRoom(t, server, member.UserName)
+
+	resp := request(t, server, http.MethodGet, "/api/stats/queues", loginAs(t, member.UserName), nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("member: expected %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/stats/queues", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+loginAs(t, service.DefaultAdminUsername))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats map[string]service.SendQueueStats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	members := connectionManager.RoomMembers(1)
+	if len(members) == 0 {
+		t.Fatal("expected a member in the room")
+	}
+	for _, conn := range members {
+		if queue, ok := stats[conn.ConnectionId]; !ok || queue.Capacity == 0 {
+			t.Fatalf("no stats of %s: %+v", conn.ConnectionId, stats)
+		}
+	}
+}
+
+func TestDefaultVoiceConfig(t *testing.T) {
+	voice := config.Default().Voice
+	if options := voiceActivityOptions(voice); options != service.DefaultVoiceActivityOptions {
+		t.Fatalf("expected %+v, got %+v", service.DefaultVoiceActivityOptions, options)
+	}
+	if voice.SendQueueSize != service.DefaultSendQueueSize || voice.MaxDroppedFrames != service.DefaultMaxDroppedFrames {
+		t.Fatalf("expected a queue of %d dropping %d frames, got %d and %d", service.DefaultSendQueueSize,
+			service.DefaultMaxDroppedFrames, voice.SendQueueSize, voice.MaxDroppedFrames)
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/gorilla/websocket"
+	"math"
+	"sync"
+	"time"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+)
+
+const (
+	mixInterval        = 20 * time.Millisecond
+	maxQueuedMixFrames = 5
+)
+
+// AudioCodec converts frame payloads to and from PCM for the mixer. Only pcm16 is built in,
+// Opus frames need a decoder the server does not have, so rooms carrying them stop mixing.
+type AudioCodec interface {
+	Decode(payload []byte) ([]int16, error)
+	Encode(pcm []int16) ([]byte, error)
+}
+
+type pcm16Codec struct{}
+
+func (codec pcm16Codec) Decode(payload []byte) ([]int16, error) {
+	if len(payload)%2 != 0 {
+		return nil, errors.New("pcm payload has an odd length")
+	}
+	pcm := make([]int16, len(payload)/2)
+	for i := range pcm {
+		pcm[i] = int16(binary.LittleEndian.Uint16(payload[i*2:]))
+	}
+	return pcm, nil
+}
+
+func (codec pcm16Codec) Encode(pcm []int16) ([]byte, error) {
+	payload := make([]byte, len(pcm)*2)
+	for i, sample := range pcm {
+		binary.LittleEndian.PutUint16(payload[i*2:], uint16(sample))
+	}
+	return payload, nil
+}
+
+// MixPcm sums the given PCM buffers sample by sample, clipping the result to the int16 range.
+// The output is as long as the longest input.
+func MixPcm(inputs [][]int16) []int16 {
+	length := 0
+	for _, input := range inputs {
+		if len(input) > length {
+			length = len(input)
+		}
+	}
+	mixed := make([]int16, length)
+	for i := range mixed {
+		sum := int32(0)
+		for _, input := range inputs {
+			if i < len(input) {
+				sum += int32(input[i])
+			}
+		}
+		if sum > math.MaxInt16 {
+			sum = math.MaxInt16
+		} else if sum < math.MinInt16 {
+			sum = math.MinInt16
+		}
+		mixed[i] = int16(sum)
+	}
+	return mixed
+}
+
+type mixListener struct {
+	sequence  uint16
+	timestamp uint32
+}
+
+// roomMixer decodes the frames of every speaker in a room and, every mixInterval, sends each
+// listener that asked for it a single frame mixing all speakers except the listener itself.
+type roomMixer struct {
+	context   *ChatRoomConnectionContext
+	codecs    map[uint8]AudioCodec
+	output    uint8
+	lock      sync.Mutex
+	speakers  map[string][][]int16
+	listeners map[string]*mixListener
+	stop      chan struct{}
+}
+
+func newRoomMixer(context *ChatRoomConnectionContext, codecs map[uint8]AudioCodec, output uint8) *roomMixer {
+	return &roomMixer{
+		context:   context,
+		codecs:    codecs,
+		output:    output,
+		speakers:  make(map[string][][]int16),
+		listeners: make(map[string]*mixListener),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (mixer *roomMixer) canDecode(codec uint8) bool {
+	_, ok := mixer.codecs[codec]
+	return ok
+}
+
+func (mixer *roomMixer) push(conn *ChatRoomConn, frame *dto.AudioFrame) error {
+	codec, ok := mixer.codecs[frame.Codec()]
+	if !ok {
+		return errors.New("no decoder for audio codec")
+	}
+	pcm, err := codec.Decode(frame.Payload)
+	if err != nil {
+		return err
+	}
+	mixer.lock.Lock()
+	defer mixer.lock.Unlock()
+	queue := append(mixer.speakers[conn.Id], pcm)
+	if len(queue) > maxQueuedMixFrames {
+		queue = queue[len(queue)-maxQueuedMixFrames:]
+	}
+	mixer.speakers[conn.Id] = queue
+	return nil
+}
+
+func (mixer *roomMixer) run() {
+	ticker := time.NewTicker(mixInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mixer.stop:
+			return
+		case <-ticker.C:
+			mixer.mix()
+		}
+	}
+}
+
+func (mixer *roomMixer) close() {
+	close(mixer.stop)
+}
+
+func (mixer *roomMixer) mix() {
+	mixer.lock.Lock()
+	frames := make(map[string][]int16)
+	for id, queue := range mixer.speakers {
+		if len(queue) == 0 {
+			delete(mixer.speakers, id)
+			continue
+		}
+		frames[id] = queue[0]
+		mixer.speakers[id] = queue[1:]
+	}
+	mixer.lock.Unlock()
+	if len(frames) == 0 {
+		return
+	}
+
+	codec := mixer.codecs[mixer.output]
+	for _, c := range mixer.context.Connections() {
+		if !c.WantsMixing() || c.VoiceState().Deafened() {
+			continue
+		}
+		inputs := make([][]int16, 0, len(frames))
+		for id, pcm := range frames {
+			if id != c.Id {
+				inputs = append(inputs, pcm)
+			}
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+		mixed := MixPcm(inputs)
+		payload, err := codec.Encode(mixed)
+		if err != nil {
+			logger.Logger.Error(err)
+			return
+		}
+
+		mixer.lock.Lock()
+		listener, ok := mixer.listeners[c.Id]
+		if !ok {
+			listener = &mixListener{}
+			mixer.listeners[c.Id] = listener
+		}
+		frame := dto.AudioFrame{
+			Version:   dto.AudioFrameVersion,
+			Flags:     mixer.output,
+			Sequence:  listener.sequence,
+			Timestamp: listener.timestamp,
+			SenderId:  dto.MixedSenderId,
+			Payload:   payload,
+		}
+		listener.sequence++
+		listener.timestamp += uint32(len(mixed))
+		mixer.lock.Unlock()
+
+		_ = c.Send(websocket.BinaryMessage, frame.Marshal())
+	}
+}
+
+func (mixer *roomMixer) remove(connId string) {
+	mixer.lock.Lock()
+	defer mixer.lock.Unlock()
+	delete(mixer.speakers, connId)
+	delete(mixer.listeners, connId)
+}
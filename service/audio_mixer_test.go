@@ -0,0 +1,171 @@
+package service
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"voice-chat-server/dto"
+)
+
+// newMixingRooms serves room 1 with mixing switched on.
+func newMixingRooms(t *testing.T) *testRooms {
+	rooms := newTestRooms(t, MediaModeRelay)
+	room, err := rooms.servers.Rooms.GetRoom(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.Mixing = true
+	if err = rooms.servers.Rooms.UpdateRoom(room); err != nil {
+		t.Fatal(err)
+	}
+	return rooms
+}
+
+func TestMixingRoomRelaysOpus(t *testing.T) {
+	rooms := newMixingRooms(t)
+	speaker := rooms.join(t, nil)
+	relayed := rooms.join(t, nil)
+	mixed := rooms.join(t, url.Values{"mix": {"1"}})
+
+	pcm := &dto.AudioFrame{
+		Version: dto.AudioFrameVersion,
+		Flags:   dto.AudioCodecPcm16,
+		Payload: []byte{1, 0, 2, 0},
+	}
+	speaker.sendFrame(pcm)
+	if frame := relayed.readFrame(); frame.Codec() != dto.AudioCodecPcm16 {
+		t.Fatalf("relayed listener got codec %d", frame.Codec())
+	}
+	if frame := mixed.readFrame(); frame.Codec() != dto.AudioCodecPcm16 || frame.SenderId != dto.MixedSenderId {
+		t.Fatalf("mixing listener got codec %d from %d", frame.Codec(), frame.SenderId)
+	}
+
+	// the mixer can not decode opus, listeners that do not mix still hear the speaker
+	speaker.sendFrame(opusFrame(1, "opus"))
+	if frame := relayed.readFrame(); frame.Codec() != dto.AudioCodecOpus || frame.SenderId != speaker.member.Ssrc {
+		t.Fatalf("relayed listener got codec %d from %d", frame.Codec(), frame.SenderId)
+	}
+	// mixing is switched off for the mixing listener, which gets the frame relayed from now on
+	mixed.expectError(dto.ErrorUnsupported)
+	if frame := mixed.readFrame(); frame.Codec() != dto.AudioCodecOpus || frame.SenderId != speaker.member.Ssrc {
+		t.Fatalf("former mixing listener got codec %d from %d", frame.Codec(), frame.SenderId)
+	}
+	speaker.expectNothing(dto.MessageJoined, dto.MessageActiveSpeakers, dto.MessageSpeakingStarted,
+		dto.MessageSpeakingStopped)
+
+	// the room no longer lets connections mix
+	late := rooms.join(t, url.Values{"mix": {"1"}})
+	late.expectError(dto.ErrorUnsupported)
+	relayed.send(dto.MessageMixing, "mix", dto.MixingPayload{Enabled: true})
+	relayed.expectError(dto.ErrorUnsupported)
+}
+
+const testSampleRate = 48000
+
+func sineWave(frequency float64, amplitude float64, samples int) []int16 {
+	pcm := make([]int16, samples)
+	for i := range pcm {
+		pcm[i] = int16(amplitude * math.Sin(2*math.Pi*frequency*float64(i)/testSampleRate))
+	}
+	return pcm
+}
+
+func TestMixPcmSineWaves(t *testing.T) {
+	low := sineWave(440, 8000, 960)
+	high := sineWave(660, 8000, 480)
+
+	mixed := MixPcm([][]int16{low, high})
+	if len(mixed) != len(low) {
+		t.Fatalf("expected %d samples, got %d", len(low), len(mixed))
+	}
+	for i, sample := range mixed {
+		expected := low[i]
+		if i < len(high) {
+			expected += high[i]
+		}
+		if sample != expected {
+			t.Fatalf("sample %d: expected %d, got %d", i, expected, sample)
+		}
+	}
+}
+
+func TestMixPcmClipping(t *testing.T) {
+	loud := sineWave(440, 30000, 960)
+
+	mixed := MixPcm([][]int16{loud, loud, loud})
+	clipped := 0
+	for i, sample := range mixed {
+		sum := 3 * int32(loud[i])
+		switch {
+		case sum > math.MaxInt16:
+			if sample != math.MaxInt16 {
+				t.Fatalf("sample %d: expected %d, got %d", i, math.MaxInt16, sample)
+			}
+			clipped++
+		case sum < math.MinInt16:
+			if sample != math.MinInt16 {
+				t.Fatalf("sample %d: expected %d, got %d", i, math.MinInt16, sample)
+			}
+			clipped++
+		case int32(sample) != sum:
+			t.Fatalf("sample %d: expected %d, got %d", i, sum, sample)
+		}
+	}
+	if clipped == 0 {
+		t.Fatal("expected clipped samples")
+	}
+}
+
+func TestMixerExcludesTheListener(t *testing.T) {
+	context := &ChatRoomConnectionContext{RoomId: 1, connections: make(map[string]*ChatRoomConn)}
+	conns := make([]*ChatRoomConn, 3)
+	for i := range conns {
+		conns[i] = newRegistryConn(i)
+		conns[i].SetMixing(true)
+		context.connections[conns[i].Id] = conns[i]
+	}
+	codec := pcm16Codec{}
+	mixer := newRoomMixer(context, map[uint8]AudioCodec{dto.AudioCodecPcm16: codec}, dto.AudioCodecPcm16)
+
+	waves := [][]int16{sineWave(440, 8000, 960), sineWave(660, 8000, 960)}
+	for i, wave := range waves {
+		payload, err := codec.Encode(wave)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := &dto.AudioFrame{Version: dto.AudioFrameVersion, Flags: dto.AudioCodecPcm16, Payload: payload}
+		if err = mixer.push(conns[i], frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mixer.mix()
+
+	expected := [][]int16{waves[1], waves[0], MixPcm(waves)}
+	for i, conn := range conns {
+		var msg outboundMessage
+		select {
+		case msg = <-conn.queue.frames:
+		default:
+			t.Fatalf("%s got no mixed frame", conn.Id)
+		}
+		frame, err := dto.ParseAudioFrame(msg.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.SenderId != dto.MixedSenderId {
+			t.Fatalf("%s: expected the mixed sender, got %d", conn.Id, frame.SenderId)
+		}
+		pcm, err := codec.Decode(frame.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pcm) != len(expected[i]) {
+			t.Fatalf("%s: expected %d samples, got %d", conn.Id, len(expected[i]), len(pcm))
+		}
+		for j := range pcm {
+			if pcm[j] != expected[i][j] {
+				t.Fatalf("%s: sample %d: expected %d, got %d", conn.Id, j, expected[i][j], pcm[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"time"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+type BanService struct {
+	Bans storage.BanRepository
+}
+
+func (service *BanService) Init() error {
+	logger.Logger.Info("Init BanService")
+	return nil
+}
+
+func (service *BanService) Ban(ban *models.ChatBan) error {
+	ban.CreateAt = time.Now().UnixNano() / int64(time.Millisecond)
+	err := service.Bans.CreateBan(ban)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not create ban")
+	}
+	return nil
+}
+
+func (service *BanService) GetBan(id int64) *models.ChatBan {
+	ban, err := service.Bans.GetBan(id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	return ban
+}
+
+func (service *BanService) Unban(id int64) error {
+	err := service.Bans.DeleteBan(id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not remove ban")
+	}
+	return nil
+}
+
+func (service *BanService) ListBans(serverId int64) ([]models.ChatBan, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	bans, err := service.Bans.ListBans(serverId, now)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list bans")
+	}
+	return bans, nil
+}
+
+// FindActiveBan returns a ban that keeps the user out of the room, either a ban on the
+// room itself or one on its whole server.
+func (service *BanService) FindActiveBan(userId int64, room *models.ChatRoom) *models.ChatBan {
+	bans, err := service.Bans.ListBansOf(userId, room.ServerId, room.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	for _, ban := range bans {
+		if !ban.IsExpired() {
+			return &ban
+		}
+	}
+	return nil
+}
@@ -1,44 +1,68 @@
 package service
 
 import (
-	"container/list"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"github.com/go-pg/pg/v9"
-	"github.com/go-pg/pg/v9/orm"
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/satori/go.uuid"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 	"voice-chat-server/dto"
 	"voice-chat-server/logger"
 	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+const (
+	CloseKicked          = 4001
+	CloseBanned          = 4003
+	CloseAccountDisabled = 4004
+	CloseRoomDeleted     = 4005
+	CloseRoomFull        = 4006
+	CloseAccessRevoked   = 4007
+)
+
+const (
+	MediaModeRelay = "relay"
+	MediaModeSfu   = "sfu"
+	MediaModeMesh  = "mesh"
 )
 
 type ChatRoomConn struct {
 	Id        string
+	Ssrc      uint32
+	User      *models.ChatUser
 	Conn      *websocket.Conn
 	Context   *ChatRoomConnectionContext
 	stop      chan struct{}
-	AfterRead func(conn *ChatRoomConn, messageType int, r io.Reader)
+	closeOnce sync.Once
+	queue     *sendQueue
+	admitted  int32
+	mixing    int32
+	activity  voiceActivity
+	voiceLock sync.RWMutex
+	voice     dto.VoiceState
+	// permissions are resolved once at connect time and refreshed when roles change.
+	permissions int64
+	AfterRead   func(conn *ChatRoomConn, messageType int, r io.Reader)
 }
 
 func (c *ChatRoomConn) listen() {
 	c.Conn.SetCloseHandler(func(code int, text string) error {
 		msg := websocket.FormatCloseMessage(code, "")
 		_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
-		for e := c.Context.Connections.Front(); e != nil; e = e.Next() {
-			if e.Value.(*ChatRoomConn) == c {
-				c.Context.Connections.Remove(e)
-				c.Context.ConnectionManager.CleanConnection(c)
-				break
-			}
-		};
 		return nil
 	})
 
+	go c.writeLoop()
+
 ReadLoop:
 	for {
 		select {
@@ -56,43 +80,158 @@ ReadLoop:
 	}
 }
 
-func (c *ChatRoomConn) Close() error {
+func (c *ChatRoomConn) Sender() *dto.Sender {
+	return &dto.Sender{
+		UserId:       c.User.Id,
+		Username:     c.User.UserName,
+		DisplayName:  c.User.Name,
+		ConnectionId: c.Id,
+		Ssrc:         c.Ssrc,
+	}
+}
+
+func (c *ChatRoomConn) Member() dto.RoomMember {
+	return dto.RoomMember{
+		Sender:     *c.Sender(),
+		VoiceState: c.VoiceState(),
+	}
+}
+
+func (c *ChatRoomConn) VoiceState() dto.VoiceState {
+	c.voiceLock.RLock()
+	defer c.voiceLock.RUnlock()
+	return c.voice
+}
+
+func (c *ChatRoomConn) updateVoiceState(update func(state *dto.VoiceState)) (dto.VoiceState, dto.VoiceState) {
+	c.voiceLock.Lock()
+	defer c.voiceLock.Unlock()
+	previous := c.voice
+	update(&c.voice)
+	return previous, c.voice
+}
+
+func (c *ChatRoomConn) HasPermission(permission models.Permission) bool {
+	return models.Permission(atomic.LoadInt64(&c.permissions))&permission == permission
+}
+
+func (c *ChatRoomConn) setPermissions(permissions models.Permission) {
+	atomic.StoreInt64(&c.permissions, int64(permissions))
+}
+
+// CanSpeak tells whether audio of the connection may reach the room.
+func (c *ChatRoomConn) CanSpeak() bool {
+	return !c.VoiceState().Muted() && c.HasPermission(models.PermissionSpeak)
+}
+
+// Admitted tells whether the connection is in its room rather than waiting for a free slot.
+func (c *ChatRoomConn) Admitted() bool {
+	return atomic.LoadInt32(&c.admitted) == 1
+}
+
+func (c *ChatRoomConn) setAdmitted() {
+	atomic.StoreInt32(&c.admitted, 1)
+}
+
+func (c *ChatRoomConn) WantsMixing() bool {
+	return atomic.LoadInt32(&c.mixing) == 1
+}
+
+func (c *ChatRoomConn) SetMixing(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&c.mixing, value)
+}
+
+func (c *ChatRoomConn) writeLoop() {
+	for {
+		var msg outboundMessage
+		select {
+		case msg = <-c.queue.messages:
+		default:
+			select {
+			case <-c.stop:
+				return
+			case msg = <-c.queue.messages:
+			case msg = <-c.queue.frames:
+			}
+		}
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.Conn.WriteMessage(msg.messageType, msg.data); err != nil {
+			logger.Logger.Errorf("Failed to write to connection %s: %v", c.Id, err)
+			_ = c.Close()
+			return
+		}
+		c.queue.drained()
+	}
+}
+
+func (c *ChatRoomConn) Send(messageType int, data []byte) error {
 	select {
 	case <-c.stop:
 		return errors.New("conn already been closed")
 	default:
-		_ = c.Conn.Close()
-		close(c.stop)
-		logger.Logger.Debug("Close connection", c.Id)
-		return nil
 	}
+	err := c.queue.push(outboundMessage{messageType: messageType, data: data})
+	if err == errSlowConsumer {
+		logger.Logger.Warningf("Disconnect slow consumer %s, queue stats: %+v", c.Id, c.queue.stats())
+		_ = c.Close()
+	}
+	return err
+}
+
+func (c *ChatRoomConn) SendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(websocket.TextMessage, data)
 }
 
-type ChatRoomConnectionContext struct {
-	RoomId            int64
-	Connections       *list.List
-	ConnectionManager *ChatRoomConnectionManager
+func (c *ChatRoomConn) QueueStats() SendQueueStats {
+	return c.queue.stats()
+}
+
+func (c *ChatRoomConn) Close() error {
+	closed := false
+	c.closeOnce.Do(func() {
+		_ = c.Conn.Close()
+		close(c.stop)
+		closed = true
+		logger.Logger.Debugf("Close connection %s, queue stats: %+v", c.Id, c.queue.stats())
+	})
+	if !closed {
+		return errors.New("conn already been closed")
+	}
+	return nil
 }
 
 type ChatRoomConnectionManager struct {
 	Upgrader          *websocket.Upgrader
 	Session           *SessionService
 	ChatServerService *ChatServerService
-	contexts          *list.List
-	DbService         *DBService
+	BanService        *BanService
+	Permissions       *PermissionService
+	rooms             *RoomRegistry
+	ConnStats         storage.ConnStatsRepository
+	SendQueueSize     int
+	OverflowPolicy    OverflowPolicy
+	MaxDroppedFrames  int
+	DefaultMediaMode  string
+	MixerCodecs       map[uint8]AudioCodec
+	MixerOutputCodec  uint8
+	VoiceActivity     VoiceActivityOptions
+	Sfu               *SelectiveForwardingUnit
+	handlers          map[string]signalHandler
+	lastSsrc          uint32
 }
 
 func (manager *ChatRoomConnectionManager) Init() error {
 	logger.Logger.Info("Init ChatRoomConnectionManager")
-	for _, model := range []interface{}{(*models.ChatUserConnStats)(nil)} {
-		err := manager.DbService.DB.CreateTable(model, &orm.CreateTableOptions{
-			IfNotExists:   true,
-			FKConstraints: true,
-		})
-		if err != nil {
-			return err
-		}
-	}
+	manager.rooms = NewRoomRegistry(manager)
+	manager.registerSignalHandlers()
 	return nil
 }
 
@@ -101,29 +240,6 @@ func writeErrResponse(w http.ResponseWriter, err error) {
 	_, _ = w.Write([]byte(err.Error()))
 }
 
-func (manager *ChatRoomConnectionManager) getContext(room *models.ChatRoom) *ChatRoomConnectionContext {
-	if manager.contexts == nil {
-		manager.contexts = list.New()
-	}
-
-	var handleContext *ChatRoomConnectionContext
-	for e := manager.contexts.Front(); e != nil; e = e.Next() {
-		if room.Id == e.Value.(*ChatRoomConnectionContext).RoomId {
-			handleContext = e.Value.(*ChatRoomConnectionContext)
-		}
-	}
-
-	if handleContext == nil {
-		handleContext = &ChatRoomConnectionContext{
-			RoomId:            room.Id,
-			Connections:       list.New(),
-			ConnectionManager: manager,
-		}
-		manager.contexts.PushFront(handleContext)
-	}
-	return handleContext
-}
-
 func (manager *ChatRoomConnectionManager) Connect(w http.ResponseWriter, r *http.Request) {
 	user := manager.Session.GetUserFromRequestParam(w, r)
 	if user == nil {
@@ -145,6 +261,42 @@ func (manager *ChatRoomConnectionManager) Connect(w http.ResponseWriter, r *http
 		return
 	}
 
+	if ban := manager.BanService.FindActiveBan(user.Id, room); ban != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "User is banned: ", ban.Reason)
+		return
+	}
+	permissions := manager.Permissions.Permissions(user, room.ServerId, room.Id)
+	if permissions == 0 && !manager.ChatServerService.IsMember(room.ServerId, user.Id) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "User is not a member of the server")
+		return
+	}
+	if permissions&models.PermissionConnect == 0 {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "Permission denied")
+		return
+	}
+	// those who manage rooms get into private and locked rooms without further checks
+	if permissions&models.PermissionManageRooms == 0 {
+		if room.Private && !manager.ChatServerService.HasRoomAccess(room.Id, user.Id) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, "Room is private")
+			return
+		}
+		password, err := base64.StdEncoding.DecodeString(r.FormValue("password"))
+		if err != nil || !manager.ChatServerService.CheckRoomPassword(room, string(password)) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprint(w, "Room password is not correct")
+			return
+		}
+	}
+	if !room.WaitingQueue && manager.rooms.IsFull(room) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, "Room is full")
+		return
+	}
+
 	c, err := manager.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Logger.Error(err)
@@ -156,17 +308,7 @@ func (manager *ChatRoomConnectionManager) Connect(w http.ResponseWriter, r *http
 		_ = c.Close()
 	}()
 
-	context := manager.getContext(room)
-
-	var connStat *models.ChatUserConnStats
-	err = manager.DbService.DB.RunInTransaction(func(tx *pg.Tx) error {
-		connStat, err = manager.AddConnectionData(user, room)
-		if err != nil {
-			logger.Logger.Error(err)
-			return err
-		}
-		return nil
-	})
+	connStat, err := manager.AddConnectionData(user, room)
 	if err != nil {
 		writeErrResponse(w, errors.New("can not stat connection"))
 		return
@@ -174,45 +316,199 @@ func (manager *ChatRoomConnectionManager) Connect(w http.ResponseWriter, r *http
 
 	newConn := ChatRoomConn{
 		Id:        connStat.Id,
+		Ssrc:      atomic.AddUint32(&manager.lastSsrc, 1),
+		User:      user,
 		Conn:      c,
-		Context:   context,
 		stop:      make(chan struct{}),
+		queue:     newSendQueue(manager.SendQueueSize, manager.OverflowPolicy, manager.MaxDroppedFrames),
 		AfterRead: manager.handleMessage,
 	}
+	newConn.SetMixing(r.FormValue("mix") == "1")
+	newConn.setPermissions(permissions)
+
+	context, queued, err := manager.rooms.Join(room, &newConn)
+	if err != nil {
+		// the room filled up between the check above and the join
+		manager.CleanConnection(&newConn)
+		msg := websocket.FormatCloseMessage(CloseRoomFull, err.Error())
+		_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
+	}
+	logger.Logger.Debugf("Connection %s established", newConn.Id)
+	if queued {
+		manager.notifyQueue(context)
+	} else {
+		manager.admit(&newConn)
+	}
+	if newConn.WantsMixing() && !context.enableMixing(&newConn, true) {
+		manager.sendError(&newConn, "", unmixable(context))
+	}
 
-	context.Connections.PushFront(&newConn)
 	newConn.listen()
+	_ = newConn.Close()
+	manager.removeConnection(&newConn)
+}
 
-	logger.Logger.Debugf("Connection %s established", newConn.Id)
+func (manager *ChatRoomConnectionManager) removeConnection(conn *ChatRoomConn) {
+	left, admitted := manager.rooms.Leave(conn)
+	if !left {
+		return
+	}
+	manager.CleanConnection(conn)
+	if !conn.Admitted() {
+		manager.notifyQueue(conn.Context)
+		return
+	}
+	if manager.Sfu != nil {
+		manager.Sfu.RemovePeer(conn.Context.RoomId, conn.Id)
+	}
+	manager.broadcast(conn.Context, conn, dto.MessageLeft, "", conn.Member())
+	for _, c := range admitted {
+		manager.admit(c)
+	}
+	if len(admitted) > 0 {
+		manager.notifyQueue(conn.Context)
+	}
+}
+
+// admit greets a connection that entered its room, either on connect or from the queue.
+func (manager *ChatRoomConnectionManager) admit(conn *ChatRoomConn) {
+	manager.send(conn, dto.MessageRoomState, "", manager.roomState(conn.Context))
+	manager.broadcast(conn.Context, conn, dto.MessageJoined, "", conn.Member())
+}
+
+func (manager *ChatRoomConnectionManager) notifyQueue(context *ChatRoomConnectionContext) {
+	waiting := context.Waiting()
+	for i, c := range waiting {
+		manager.send(c, dto.MessageQueued, "", dto.QueuePayload{
+			RoomId:   context.RoomId,
+			Position: i + 1,
+			Size:     len(waiting),
+		})
+	}
 }
 
 func (manager *ChatRoomConnectionManager) handleMessage(conn *ChatRoomConn, messageType int, r io.Reader) {
-	var msg dto.Message
-	decoder := json.NewDecoder(r)
-	if err := decoder.Decode(&msg); err != nil {
+	if !conn.Admitted() {
+		manager.handleQueuedMessage(conn, messageType, r)
 		return
 	}
+	switch messageType {
+	case websocket.BinaryMessage:
+		manager.handleAudioFrame(conn, r)
+	case websocket.TextMessage:
+		manager.handleTextMessage(conn, r)
+	default:
+		logger.Logger.Debugf("Ignore message of type %d from connection %s", messageType, conn.Id)
+	}
+}
 
-	logger.Logger.Debug("Receive message:", msg)
+// handleQueuedMessage only keeps a waiting connection alive, everything else has to wait until
+// the connection is admitted.
+func (manager *ChatRoomConnectionManager) handleQueuedMessage(conn *ChatRoomConn, messageType int, r io.Reader) {
+	if messageType != websocket.TextMessage {
+		return
+	}
+	var msg dto.Envelope
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		manager.sendError(conn, "", badRequest("malformed message: %v", err))
+		return
+	}
+	switch msg.Type {
+	case dto.MessagePing, dto.MessagePong, dto.MessageLeave:
+		manager.dispatch(conn, &msg)
+	default:
+		manager.sendError(conn, msg.Id, &SignalError{
+			Code:    dto.ErrorRoomFull,
+			Message: fmt.Sprintf("waiting for a free slot in room %d", conn.Context.RoomId),
+		})
+	}
+}
 
-	for e := conn.Context.Connections.Front(); e != nil; e = e.Next() {
-		c := e.Value.(*ChatRoomConn)
-		if c == conn {
-			continue
+func (manager *ChatRoomConnectionManager) mediaMode(room *models.ChatRoom) string {
+	if room.MediaMode != "" {
+		return room.MediaMode
+	}
+	if manager.DefaultMediaMode != "" {
+		return manager.DefaultMediaMode
+	}
+	return MediaModeRelay
+}
+
+func (manager *ChatRoomConnectionManager) mixerCodecs() map[uint8]AudioCodec {
+	if manager.MixerCodecs != nil {
+		return manager.MixerCodecs
+	}
+	return map[uint8]AudioCodec{dto.AudioCodecPcm16: pcm16Codec{}}
+}
+
+func (manager *ChatRoomConnectionManager) mixerOutputCodec() uint8 {
+	if _, ok := manager.mixerCodecs()[manager.MixerOutputCodec]; ok {
+		return manager.MixerOutputCodec
+	}
+	return dto.AudioCodecPcm16
+}
+
+func (manager *ChatRoomConnectionManager) handleAudioFrame(conn *ChatRoomConn, r io.Reader) {
+	if conn.Context.MediaMode != MediaModeRelay {
+		logger.Logger.Debugf("Drop audio frame from connection %s, room %d uses %s mode",
+			conn.Id, conn.Context.RoomId, conn.Context.MediaMode)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, dto.AudioFrameHeaderSize+dto.MaxAudioPayloadSize+2))
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	frame, err := dto.ParseAudioFrame(data)
+	if err != nil {
+		logger.Logger.Debugf("Drop invalid audio frame from connection %s: %v", conn.Id, err)
+		return
+	}
+	if !conn.CanSpeak() || !manager.admitSpeaker(conn, time.Now()) {
+		return
+	}
+	frame.SenderId = conn.Ssrc
+	data = frame.Marshal()
+	manager.detectVoiceActivity(conn, frame)
+
+	mixer := conn.Context.Mixer()
+	if mixer != nil && !mixer.canDecode(frame.Codec()) {
+		// the mix would leave the speaker out, so every listener gets the frames relayed instead
+		manager.stopMixing(conn.Context)
+		mixer = nil
+	}
+	mixed := mixer != nil
+	if mixed {
+		if err = mixer.push(conn, frame); err != nil {
+			logger.Logger.Debugf("Failed to mix audio frame from connection %s: %v", conn.Id, err)
 		}
-		err := c.Conn.WriteJSON(msg)
-		if err != nil {
-			logger.Logger.Error("Failed to send message to connection")
+	}
+	for _, c := range conn.Context.Connections() {
+		if c == conn || c.VoiceState().Deafened() || (mixed && c.WantsMixing()) {
+			continue
 		}
+		_ = c.Send(websocket.BinaryMessage, data)
+	}
+}
+
+// stopMixing tells the listeners of the room once that mixing is switched off, because a speaker
+// sends a codec the mixer can not decode.
+func (manager *ChatRoomConnectionManager) stopMixing(context *ChatRoomConnectionContext) {
+	for _, c := range context.markUnmixable() {
+		manager.sendError(c, "", unmixable(context))
+	}
+}
+
+func unmixable(context *ChatRoomConnectionContext) *SignalError {
+	return &SignalError{
+		Code:    dto.ErrorUnsupported,
+		Message: fmt.Sprintf("room %d carries audio the mixer can not decode, only pcm16 rooms mix", context.RoomId),
 	}
 }
 
 func (manager *ChatRoomConnectionManager) AddConnectionData(user *models.ChatUser, room *models.ChatRoom) (*models.ChatUserConnStats, error) {
-	var existConn []models.ChatUserConnStats
-	err := manager.DbService.DB.Model(&existConn).
-		Where("user_id = ?", user.Id).
-		Where("room_id = ?", room.Id).
-		Select()
+	existConn, err := manager.ConnStats.ListConnStats(user.Id, room.Id)
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil, err
@@ -230,7 +526,7 @@ func (manager *ChatRoomConnectionManager) AddConnectionData(user *models.ChatUse
 		UserId: user.Id,
 		RoomId: room.Id,
 	}
-	err = manager.DbService.DB.Insert(&connStat)
+	err = manager.ConnStats.CreateConnStats(&connStat)
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil, err
@@ -239,39 +535,127 @@ func (manager *ChatRoomConnectionManager) AddConnectionData(user *models.ChatUse
 }
 
 func (manager *ChatRoomConnectionManager) CleanConnection(conn *ChatRoomConn) {
-	connId := conn.Id
-	_, err := manager.DbService.DB.Model((*models.ChatUserConnStats)(nil)).
-		Where("id = ?", connId).
-		Delete()
+	err := manager.ConnStats.DeleteConnStats(conn.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) CleanUserConnections(userId int64) {
+	err := manager.ConnStats.DeleteUserConnStats(userId)
 	if err != nil {
 		logger.Logger.Error(err)
 	}
 }
 
 func (manager *ChatRoomConnectionManager) CloseConnection(conn *models.ChatUserConnStats) {
-	var handleContext *ChatRoomConnectionContext
-	if manager.contexts == nil {
+	c := manager.rooms.Find(conn.RoomId, conn.Id)
+	if c == nil {
+		err := manager.ConnStats.DeleteConnStats(conn.Id)
+		if err != nil {
+			logger.Logger.Error(err)
+		}
 		return
 	}
-	for e := manager.contexts.Front(); e != nil; e = e.Next() {
-		if conn.RoomId == e.Value.(*ChatRoomConnectionContext).RoomId {
-			handleContext = e.Value.(*ChatRoomConnectionContext)
-			break
+	err := c.Close()
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+	manager.removeConnection(c)
+}
+
+func (manager *ChatRoomConnectionManager) RoomMembers(roomId int64) []dto.RoomMember {
+	context := manager.rooms.Get(roomId)
+	if context == nil {
+		return make([]dto.RoomMember, 0)
+	}
+	return manager.roomState(context).Members
+}
+
+// DisconnectUser closes every connection of the user in the given room, in every room of
+// the server when roomId is zero, or everywhere when serverId is zero too. It returns how
+// many connections were closed.
+func (manager *ChatRoomConnectionManager) DisconnectUser(serverId int64, roomId int64, userId int64, code int, reason string) int {
+	closed := 0
+	for _, context := range manager.rooms.Rooms() {
+		if (serverId != 0 && context.ServerId != serverId) || (roomId != 0 && context.RoomId != roomId) {
+			continue
 		}
+		for _, c := range append(context.Connections(), context.Waiting()...) {
+			if c.User.Id != userId {
+				continue
+			}
+			manager.disconnect(c, code, reason)
+			closed++
+		}
+	}
+	return closed
+}
+
+// DisconnectRoom closes every connection of the room and returns how many were closed.
+func (manager *ChatRoomConnectionManager) DisconnectRoom(roomId int64, code int, reason string) int {
+	context := manager.rooms.Get(roomId)
+	if context == nil {
+		return 0
+	}
+	closed := 0
+	for _, c := range append(context.Connections(), context.Waiting()...) {
+		manager.disconnect(c, code, reason)
+		closed++
 	}
-	if handleContext == nil || handleContext.Connections == nil {
+	return closed
+}
+
+func (manager *ChatRoomConnectionManager) disconnect(c *ChatRoomConn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	_ = c.Close()
+	manager.removeConnection(c)
+}
+
+// UpdateRoom applies changed room settings to a live room. The media mode of a live room
+// stays as it is until the room empties.
+func (manager *ChatRoomConnectionManager) UpdateRoom(room *models.ChatRoom) {
+	context := manager.rooms.Get(room.Id)
+	if context == nil {
 		return
 	}
-	for e := handleContext.Connections.Front(); e != nil; e = e.Next() {
-		c := e.Value.(*ChatRoomConn)
-		if c.Id == conn.Id {
-			err := c.Close()
-			if err != nil {
-				logger.Logger.Error(err)
+	admitted := manager.rooms.Update(room)
+	for _, c := range admitted {
+		manager.admit(c)
+	}
+	if len(admitted) > 0 {
+		manager.notifyQueue(context)
+	}
+	if context.MediaMode != MediaModeRelay {
+		return
+	}
+	if (context.Mixer() != nil) != room.Mixing {
+		context.SetMixing(room.Mixing)
+		manager.broadcast(context, nil, dto.MessageMixing, "", dto.MixingPayload{Enabled: room.Mixing, Room: true})
+	}
+}
+
+func (manager *ChatRoomConnectionManager) RefreshPermissions(userId int64) {
+	for _, context := range manager.rooms.Rooms() {
+		for _, c := range context.Connections() {
+			if c.User.Id != userId {
+				continue
 			}
-			handleContext.Connections.Remove(e)
-			handleContext.ConnectionManager.CleanConnection(c)
-			break
+			c.setPermissions(manager.Permissions.Permissions(c.User, context.ServerId, context.RoomId))
+			if manager.Sfu != nil && context.MediaMode == MediaModeSfu {
+				manager.Sfu.SetMuted(context.RoomId, c.Id, !c.CanSpeak())
+			}
+		}
+	}
+}
+
+func (manager *ChatRoomConnectionManager) QueueStats() map[string]SendQueueStats {
+	stats := make(map[string]SendQueueStats)
+	for _, context := range manager.rooms.Rooms() {
+		for _, c := range context.Connections() {
+			stats[c.Id] = c.QueueStats()
 		}
-	};
+	}
+	return stats
 }
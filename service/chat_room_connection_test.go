@@ -0,0 +1,280 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"voice-chat-server/dto"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+// testRooms serves /ws/connect of a connection manager backed by a memory store holding
+// server 1 and its room 1.
+type testRooms struct {
+	manager  *ChatRoomConnectionManager
+	servers  *ChatServerService
+	users    *ChatUserService
+	sessions *SessionService
+	server   *httptest.Server
+	lastUser int32
+}
+
+// testClient is a websocket client that joined a room.
+type testClient struct {
+	*websocket.Conn
+	t      *testing.T
+	member dto.RoomMember
+}
+
+func newTestRooms(t *testing.T, mediaMode string) *testRooms {
+	store := storage.NewMemoryStore()
+	users := &ChatUserService{Users: store}
+	servers := &ChatServerService{Servers: store, Rooms: store}
+	rooms := &testRooms{
+		servers:  servers,
+		users:    users,
+		sessions: &SessionService{Sessions: store, UserService: users},
+	}
+	permissions := &PermissionService{Roles: store, UserService: users, ChatServerService: servers}
+	rooms.manager = &ChatRoomConnectionManager{
+		Upgrader:          &websocket.Upgrader{},
+		Session:           rooms.sessions,
+		ChatServerService: servers,
+		BanService:        &BanService{Bans: store},
+		Permissions:       permissions,
+		ConnStats:         store,
+		SendQueueSize:     DefaultSendQueueSize,
+		OverflowPolicy:    OverflowDropOldest,
+		MaxDroppedFrames:  DefaultMaxDroppedFrames,
+		DefaultMediaMode:  mediaMode,
+		MixerOutputCodec:  dto.AudioCodecPcm16,
+		VoiceActivity:     DefaultVoiceActivityOptions,
+	}
+	if err := store.CreateServer(&models.ChatServer{Id: 1, Name: "server", Description: "server"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateRoom(&models.ChatRoom{Id: 1, Name: "room", Description: "room", ServerId: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := permissions.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rooms.manager.Init(); err != nil {
+		t.Fatal(err)
+	}
+	rooms.server = httptest.NewServer(http.HandlerFunc(rooms.manager.Connect))
+	t.Cleanup(rooms.server.Close)
+	return rooms
+}
+
+// login creates a new member of the server and returns its username and access token.
+func (rooms *testRooms) login(t *testing.T) (string, string) {
+	username := fmt.Sprintf("user-%d", atomic.AddInt32(&rooms.lastUser, 1))
+	user, err := rooms.users.CreateUser(username, "secret-password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rooms.servers.AddMember(1, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	token, err := rooms.sessions.Login(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return username, token.Token
+}
+
+// dial connects with the token to the room, query adds to the parameters.
+func (rooms *testRooms) dial(roomId int64, token string, query url.Values) (*websocket.Conn, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("room", fmt.Sprint(roomId))
+	query.Set("Authorization", token)
+	address := "ws" + strings.TrimPrefix(rooms.server.URL, "http") + "?" + query.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(address, nil)
+	return conn, err
+}
+
+// join connects to room 1 and waits for the state of the room.
+func (rooms *testRooms) join(t *testing.T, query url.Values) *testClient {
+	username, token := rooms.login(t)
+	conn, err := rooms.dial(1, token, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &testClient{Conn: conn, t: t}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	var state dto.RoomStatePayload
+	client.readPayload(dto.MessageRoomState, &state)
+	for _, member := range state.Members {
+		if member.Username == username {
+			client.member = member
+		}
+	}
+	return client
+}
+
+func (client *testClient) read() (int, []byte) {
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, data, err := client.ReadMessage()
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	return messageType, data
+}
+
+// readEnvelope skips binary frames and messages of other types.
+func (client *testClient) readEnvelope(messageType string) dto.Envelope {
+	for {
+		kind, data := client.read()
+		if kind != websocket.TextMessage {
+			continue
+		}
+		var msg dto.Envelope
+		if err := json.Unmarshal(data, &msg); err != nil {
+			client.t.Fatal(err)
+		}
+		if msg.Type == messageType {
+			return msg
+		}
+	}
+}
+
+func (client *testClient) readPayload(messageType string, payload interface{}) {
+	msg := client.readEnvelope(messageType)
+	if err := json.Unmarshal(msg.Payload, payload); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+// readFrame skips text messages.
+func (client *testClient) readFrame() *dto.AudioFrame {
+	for {
+		kind, data := client.read()
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+		frame, err := dto.ParseAudioFrame(data)
+		if err != nil {
+			client.t.Fatal(err)
+		}
+		return frame
+	}
+}
+
+func (client *testClient) send(messageType string, id string, payload interface{}) {
+	msg, err := dto.NewEnvelope(messageType, id, payload)
+	if err != nil {
+		client.t.Fatal(err)
+	}
+	if err = client.WriteJSON(msg); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+func (client *testClient) sendFrame(frame *dto.AudioFrame) {
+	if err := client.WriteMessage(websocket.BinaryMessage, frame.Marshal()); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+func opusFrame(sequence uint16, payload string) *dto.AudioFrame {
+	return &dto.AudioFrame{
+		Version:   dto.AudioFrameVersion,
+		Flags:     dto.AudioCodecOpus,
+		Sequence:  sequence,
+		Timestamp: uint32(sequence) * 960,
+		SenderId:  12345,
+		Payload:   []byte(payload),
+	}
+}
+
+func TestRelayAudioFrames(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeRelay)
+	speaker := rooms.join(t, nil)
+	listeners := []*testClient{rooms.join(t, nil), rooms.join(t, nil), rooms.join(t, nil)}
+
+	for i := uint16(1); i <= 3; i++ {
+		speaker.sendFrame(opusFrame(i, fmt.Sprintf("packet %d", i)))
+	}
+	for _, listener := range listeners {
+		for i := uint16(1); i <= 3; i++ {
+			frame := listener.readFrame()
+			if frame.Sequence != i || string(frame.Payload) != fmt.Sprintf("packet %d", i) {
+				t.Fatalf("expected packet %d, got %d %q", i, frame.Sequence, frame.Payload)
+			}
+			// the sender id is always the one of the connection
+			if frame.SenderId != speaker.member.Ssrc {
+				t.Fatalf("expected sender %d, got %d", speaker.member.Ssrc, frame.SenderId)
+			}
+		}
+	}
+
+	// the speaker does not hear itself, the next frame it gets comes from someone else
+	listeners[0].sendFrame(opusFrame(7, "answer"))
+	if frame := speaker.readFrame(); frame.SenderId != listeners[0].member.Ssrc || frame.Sequence != 7 {
+		t.Fatalf("speaker got its own frame back: %+v", frame)
+	}
+}
+
+func TestRelayDropsInvalidFrames(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeRelay)
+	speaker := rooms.join(t, nil)
+	listener := rooms.join(t, nil)
+
+	invalid := opusFrame(1, "bad version")
+	invalid.Version = 2
+	if err := speaker.WriteMessage(websocket.BinaryMessage, invalid.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if err := speaker.WriteMessage(websocket.BinaryMessage, []byte{dto.AudioFrameVersion, 0}); err != nil {
+		t.Fatal(err)
+	}
+	pcm := opusFrame(2, "odd")
+	pcm.Flags = dto.AudioCodecPcm16
+	speaker.sendFrame(pcm)
+	speaker.sendFrame(opusFrame(3, "valid"))
+
+	if frame := listener.readFrame(); frame.Sequence != 3 {
+		t.Fatalf("expected only the valid frame, got %d", frame.Sequence)
+	}
+}
+
+func TestRelaySkipsDeafenedListeners(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeRelay)
+	speaker := rooms.join(t, nil)
+	deafened := rooms.join(t, nil)
+	listener := rooms.join(t, nil)
+
+	deafened.send(dto.MessageDeafen, "deafen", nil)
+	listener.readEnvelope(dto.MessageVoiceState)
+	speaker.sendFrame(opusFrame(1, "first"))
+	listener.readFrame()
+
+	// a ping answered after the frame shows nothing was queued for the deafened listener
+	deafened.send(dto.MessagePing, "ping", dto.PingPayload{})
+	for {
+		kind, data := deafened.read()
+		if kind == websocket.BinaryMessage {
+			t.Fatal("deafened listener got a frame")
+		}
+		var msg dto.Envelope
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == dto.MessagePong {
+			break
+		}
+	}
+}
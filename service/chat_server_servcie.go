@@ -2,27 +2,20 @@ package service
 
 import (
 	"errors"
-	"github.com/go-pg/pg/v9/orm"
+	"golang.org/x/crypto/bcrypt"
+	"time"
 	"voice-chat-server/logger"
 	"voice-chat-server/models"
+	"voice-chat-server/storage"
 )
 
 type ChatServerService struct {
-	DbService *DBService
+	Servers storage.ServerRepository
+	Rooms   storage.RoomRepository
 }
 
 func (service *ChatServerService) Init() error {
 	logger.Logger.Info("Init ChatServerService")
-	for _, model := range []interface{}{(*models.ChatServer)(nil), (*models.ChatRoom)(nil)} {
-		err := service.DbService.DB.CreateTable(model, &orm.CreateTableOptions{
-			IfNotExists:   true,
-			FKConstraints: true,
-		})
-		if err != nil {
-			return err
-		}
-	}
-
 	server := service.GetServerById(1)
 
 	var serverInfo models.ChatServer
@@ -32,8 +25,9 @@ func (service *ChatServerService) Init() error {
 			Id:          1,
 			Name:        "Default server",
 			Description: "Default server",
+			Public:      true,
 		}
-		err := service.DbService.DB.Insert(&serverInfo)
+		err := service.Servers.CreateServer(&serverInfo)
 		if err != nil {
 			return err
 		}
@@ -49,7 +43,7 @@ func (service *ChatServerService) Init() error {
 				Description: "Default room",
 				ServerId:    serverInfo.Id,
 			}
-			err = service.DbService.DB.Insert(&roomInfo)
+			err = service.Rooms.CreateRoom(&roomInfo)
 			if err != nil {
 				return err
 			}
@@ -60,73 +54,87 @@ func (service *ChatServerService) Init() error {
 }
 
 func (service *ChatServerService) GetServerById(id int64) *models.ChatServer {
-	var server models.ChatServer
-	err := service.DbService.DB.Model(&server).Where("id = ?", id).Select()
+	server, err := service.Servers.GetServer(id)
 	if err != nil {
-		logger.Logger.Error(err)
+		if err != storage.ErrNotFound {
+			logger.Logger.Error(err)
+		}
 		return nil
 	}
-	return &server
+	return server
 }
 
 func (service *ChatServerService) GetRoomById(id int64) *models.ChatRoom {
-	var room models.ChatRoom
-	err := service.DbService.DB.Model(&room).Where("id = ?", id).Select()
+	room, err := service.Rooms.GetRoom(id)
 	if err != nil {
-		logger.Logger.Error(err)
+		if err != storage.ErrNotFound {
+			logger.Logger.Error(err)
+		}
 		return nil
 	}
-	return &room
+	room.Locked = room.Password != ""
+	return room
 }
 
 func (service *ChatServerService) ListServers() []models.ChatServerData {
 	var serverList []models.ChatServerData
 
-	var servers []models.ChatServer
-	err := service.DbService.DB.Model(&servers).Select()
+	servers, err := service.Servers.ListServers()
 	if err != nil {
 		logger.Logger.Error(err)
 		return serverList
 	}
 	for _, server := range servers {
-		serverData := models.ChatServerData{
-			Id:          server.Id,
-			Name:        server.Name,
-			Description: server.Description,
-		}
-		serverList = append(serverList, serverData)
+		serverList = append(serverList, serverData(&server))
+	}
+	return serverList
+}
+
+// ListServersOf returns the servers the user is a member of.
+func (service *ChatServerService) ListServersOf(userId int64) []models.ChatServerData {
+	serverList := make([]models.ChatServerData, 0)
+
+	servers, err := service.Servers.ListServersOf(userId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return serverList
+	}
+	for _, server := range servers {
+		serverList = append(serverList, serverData(&server))
 	}
 	return serverList
 }
 
+func serverData(server *models.ChatServer) models.ChatServerData {
+	return models.ChatServerData{
+		Id:          server.Id,
+		Name:        server.Name,
+		Description: server.Description,
+		Position:    server.Position,
+		Public:      server.Public,
+	}
+}
+
 func (service *ChatServerService) GetServerInfo(serveId int64) (*models.ChatServerData, error) {
 	serverInfo := service.GetServerById(serveId)
 	if serverInfo == nil {
 		return nil, errors.New("can not find server")
 	}
-	var serverData = models.ChatServerData{
-		Id:          serverInfo.Id,
-		Name:        serverInfo.Name,
-		Description: serverInfo.Description,
-	}
-	return &serverData, nil
+	data := serverData(serverInfo)
+	return &data, nil
 }
 
 func (service *ChatServerService) ListRooms(serverId int64) ([]models.ChatRoom, error) {
-	var rooms []models.ChatRoom
-	err := service.DbService.DB.Model(&rooms).
-		Column("chat_room.*").
-		Relation("Server").
-		Join("join chat_server as svr").
-		JoinOn("svr.id = chat_room.server_id").
-		JoinOn("svr.id = ?", serverId).
-		Select()
+	rooms, err := service.Rooms.ListRooms(serverId)
 	if err != nil {
 		logger.Logger.Error(err)
 	}
-	if err != nil || rooms == nil {
+	if err != nil || len(rooms) == 0 {
 		return nil, errors.New("can not find rooms")
 	}
+	for i := range rooms {
+		rooms[i].Locked = rooms[i].Password != ""
+	}
 	return rooms, nil
 }
 
@@ -137,3 +145,247 @@ func (service *ChatServerService) GetRoom(roomId int64) (*models.ChatRoom, error
 	}
 	return roomInfo, nil
 }
+
+func validateName(name string) error {
+	if name == "" || len(name) > 255 {
+		return errors.New("name must be 1 to 255 characters")
+	}
+	return nil
+}
+
+func validateServer(server *models.ChatServer) error {
+	if err := validateName(server.Name); err != nil {
+		return err
+	}
+	if len(server.Description) > 255 {
+		return errors.New("description is too long")
+	}
+	return nil
+}
+
+func validateRoom(room *models.ChatRoom) error {
+	if err := validateName(room.Name); err != nil {
+		return err
+	}
+	if len(room.Description) > 255 {
+		return errors.New("description is too long")
+	}
+	switch room.MediaMode {
+	case "", MediaModeRelay, MediaModeSfu, MediaModeMesh:
+	default:
+		return errors.New("unknown media mode")
+	}
+	if room.Capacity < 0 || room.MaxSpeakers < 0 {
+		return errors.New("room limits can not be negative")
+	}
+	return nil
+}
+
+func (service *ChatServerService) CreateServer(server *models.ChatServer) error {
+	if err := validateServer(server); err != nil {
+		return err
+	}
+	err := service.Servers.CreateServer(server)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not create server")
+	}
+	return nil
+}
+
+func (service *ChatServerService) UpdateServer(server *models.ChatServer) error {
+	if err := validateServer(server); err != nil {
+		return err
+	}
+	err := service.Servers.UpdateServer(server)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not update server")
+	}
+	return nil
+}
+
+// DeleteServer removes the server together with all of its rooms. Live connections of the
+// rooms have to be closed after, the deleted rooms already turn away new ones.
+func (service *ChatServerService) DeleteServer(serverId int64) error {
+	err := service.Servers.DeleteServer(serverId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not delete server")
+	}
+	return nil
+}
+
+func (service *ChatServerService) CreateRoom(room *models.ChatRoom) error {
+	if err := validateRoom(room); err != nil {
+		return err
+	}
+	if service.GetServerById(room.ServerId) == nil {
+		return errors.New("can not find server")
+	}
+	err := service.Rooms.CreateRoom(room)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not create room")
+	}
+	return nil
+}
+
+func (service *ChatServerService) UpdateRoom(room *models.ChatRoom) error {
+	if err := validateRoom(room); err != nil {
+		return err
+	}
+	err := service.Rooms.UpdateRoom(room)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not update room")
+	}
+	return nil
+}
+
+// DeleteRoom removes the room, its connection records and the bans and invites limited to
+// it. Live connections of the room have to be closed after, the deleted room already turns
+// away new ones.
+func (service *ChatServerService) DeleteRoom(roomId int64) error {
+	err := service.Rooms.DeleteRoom(roomId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not delete room")
+	}
+	return nil
+}
+
+// SetRoomPassword hashes the password into the room without saving it, an empty password
+// unlocks the room.
+func (service *ChatServerService) SetRoomPassword(room *models.ChatRoom, password string) error {
+	if password == "" {
+		room.Password = ""
+		room.Locked = false
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not set room password")
+	}
+	room.Password = string(hash)
+	room.Locked = true
+	return nil
+}
+
+func (service *ChatServerService) CheckRoomPassword(room *models.ChatRoom, password string) bool {
+	if room.Password == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(room.Password), []byte(password)) == nil
+}
+
+func (service *ChatServerService) HasRoomAccess(roomId int64, userId int64) bool {
+	access, err := service.Rooms.HasRoomAccess(roomId, userId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return false
+	}
+	return access
+}
+
+func (service *ChatServerService) ListRoomAccess(roomId int64) ([]models.ChatRoomAccess, error) {
+	access, err := service.Rooms.ListRoomAccess(roomId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list room access")
+	}
+	return access, nil
+}
+
+func (service *ChatServerService) GrantRoomAccess(roomId int64, userId int64, createdBy int64) error {
+	if service.HasRoomAccess(roomId, userId) {
+		return nil
+	}
+	access := models.ChatRoomAccess{
+		RoomId:    roomId,
+		UserId:    userId,
+		CreatedBy: createdBy,
+		CreateAt:  time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	err := service.Rooms.CreateRoomAccess(&access)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not grant room access")
+	}
+	return nil
+}
+
+func (service *ChatServerService) RevokeRoomAccess(roomId int64, userId int64) error {
+	err := service.Rooms.DeleteRoomAccess(roomId, userId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not revoke room access")
+	}
+	return nil
+}
+
+func (service *ChatServerService) GetMember(serverId int64, userId int64) *models.ChatServerMember {
+	member, err := service.Servers.GetMember(serverId, userId)
+	if err != nil {
+		return nil
+	}
+	return member
+}
+
+func (service *ChatServerService) IsMember(serverId int64, userId int64) bool {
+	return service.GetMember(serverId, userId) != nil
+}
+
+func (service *ChatServerService) ListMembers(serverId int64) ([]models.ChatServerMember, error) {
+	members, err := service.Servers.ListMembers(serverId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list members")
+	}
+	return members, nil
+}
+
+// AddMember makes the user a member of the server, joining twice is not an error.
+func (service *ChatServerService) AddMember(serverId int64, userId int64) error {
+	if service.IsMember(serverId, userId) {
+		return nil
+	}
+	member := models.ChatServerMember{
+		ServerId: serverId,
+		UserId:   userId,
+		JoinedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	err := service.Servers.CreateMember(&member)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not join server")
+	}
+	return nil
+}
+
+// RemoveMember drops the membership together with the role of the user on the server and
+// its rooms.
+func (service *ChatServerService) RemoveMember(serverId int64, userId int64) error {
+	err := service.Servers.DeleteMember(serverId, userId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not leave server")
+	}
+	return nil
+}
+
+func (service *ChatServerService) SetNickname(serverId int64, userId int64, nickname string) error {
+	if len(nickname) > 64 {
+		return errors.New("nickname is too long")
+	}
+	err := service.Servers.SetNickname(serverId, userId, nickname)
+	if err == storage.ErrNotFound {
+		return errors.New("user is not a member of the server")
+	}
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not update nickname")
+	}
+	return nil
+}
@@ -1,30 +1,41 @@
 package service
 
 import (
-	"github.com/go-pg/pg/v9/orm"
-	_ "github.com/lib/pq"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
+	"regexp"
 	"voice-chat-server/logger"
 	"voice-chat-server/models"
+	"voice-chat-server/storage"
 )
 
+const DefaultAdminUsername = "admin"
+
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite"
+	RegistrationClosed     = "closed"
+)
+
+const minPasswordLength = 6
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+// RegistrationInvites lets invite-only registration check and consume invite codes.
+type RegistrationInvites interface {
+	ValidateInvite(code string) error
+	RedeemInvite(user *models.ChatUser, code string) error
+}
+
 type ChatUserService struct {
-	DbService *DBService
+	Users            storage.UserRepository
+	RegistrationMode string
+	Invites          RegistrationInvites
 }
 
 func (service *ChatUserService) Init() error {
 	logger.Logger.Info("Init ChatUserService")
-	for _, model := range []interface{}{(*models.ChatUser)(nil)} {
-		err := service.DbService.DB.CreateTable(model, &orm.CreateTableOptions{
-			IfNotExists:   true,
-			FKConstraints: true,
-		})
-		if err != nil {
-			return err
-		}
-	}
-
-	user := service.GetUserByUsername("admin")
+	user := service.GetUserByUsername(DefaultAdminUsername)
 	if user == nil {
 		logger.Logger.Info("Init default user: 'admin'")
 		hash, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
@@ -32,10 +43,10 @@ func (service *ChatUserService) Init() error {
 			return err
 		}
 		encodePW := string(hash)
-		err = service.DbService.DB.Insert(&models.ChatUser{
+		err = service.Users.CreateUser(&models.ChatUser{
 			Id:       1,
-			Name:     "admin",
-			UserName: "admin",
+			Name:     DefaultAdminUsername,
+			UserName: DefaultAdminUsername,
 			Password: encodePW,
 		})
 		if err != nil {
@@ -47,13 +58,14 @@ func (service *ChatUserService) Init() error {
 }
 
 func (service *ChatUserService) GetUserByUsername(username string) *models.ChatUser {
-	var user models.ChatUser
-	err := service.DbService.DB.Model(&user).Where("user_name = ?", username).Select()
+	user, err := service.Users.GetUserByUsername(username)
 	if err != nil {
-		logger.Logger.Error(err)
+		if err != storage.ErrNotFound {
+			logger.Logger.Error(err)
+		}
 		return nil
 	}
-	return &user
+	return user
 }
 
 func (service *ChatUserService) UpdatePassword(username string, newPwd string) *models.ChatUser {
@@ -70,8 +82,9 @@ func (service *ChatUserService) UpdatePassword(username string, newPwd string) *
 	encodePW := string(hash)
 	user.Password = encodePW
 
-	err = service.DbService.DB.Update(&user)
+	err = service.Users.UpdateUser(user)
 	if err != nil {
+		logger.Logger.Error(err)
 		return nil
 	}
 	return user
@@ -80,7 +93,7 @@ func (service *ChatUserService) UpdatePassword(username string, newPwd string) *
 func (service *ChatUserService) AuthUser(username string, password string) *models.ChatUser {
 	usernameQL := service.GetUserByUsername(username)
 
-	if usernameQL == nil {
+	if usernameQL == nil || usernameQL.Disabled {
 		return nil
 	}
 	err := bcrypt.CompareHashAndPassword([]byte(usernameQL.Password), []byte(password))
@@ -91,3 +104,159 @@ func (service *ChatUserService) AuthUser(username string, password string) *mode
 
 	return usernameQL
 }
+
+func (service *ChatUserService) IsAdministrator(user *models.ChatUser) bool {
+	return user != nil && user.UserName == DefaultAdminUsername
+}
+
+func (service *ChatUserService) GetUserById(id int64) *models.ChatUser {
+	user, err := service.Users.GetUser(id)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			logger.Logger.Error(err)
+		}
+		return nil
+	}
+	return user
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("password is too short")
+	}
+	return nil
+}
+
+// Register creates a new account according to the registration mode. In invite-only mode
+// the invite code is validated before and redeemed after the account is created, the account
+// is removed again when the invite can not be redeemed.
+func (service *ChatUserService) Register(username string, password string, name string, inviteCode string) (*models.ChatUser, error) {
+	switch service.RegistrationMode {
+	case RegistrationClosed:
+		return nil, errors.New("registration is closed")
+	case RegistrationInviteOnly:
+		if service.Invites == nil {
+			return nil, errors.New("registration requires an invite")
+		}
+		if err := service.Invites.ValidateInvite(inviteCode); err != nil {
+			return nil, err
+		}
+	}
+	user, err := service.CreateUser(username, password, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the invite is only counted here, concurrent sign-ups past its last use lose their account
+	if service.RegistrationMode == RegistrationInviteOnly {
+		if err = service.Invites.RedeemInvite(user, inviteCode); err != nil {
+			if deleteErr := service.DeleteUser(user); deleteErr != nil {
+				logger.Logger.Error(deleteErr)
+			}
+			return nil, err
+		}
+	}
+	logger.Logger.Infof("User '%s' registered", username)
+	return user, nil
+}
+
+// CreateUser creates an account whatever the registration mode is, the name defaults to the
+// username.
+func (service *ChatUserService) CreateUser(username string, password string, name string) (*models.ChatUser, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, errors.New("username must be 3 to 32 letters, digits, '_', '.' or '-'")
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = username
+	}
+	if len(name) > 255 {
+		return nil, errors.New("name is too long")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.ChatUser{
+		Name:     name,
+		UserName: username,
+		Password: string(hash),
+	}
+	err = service.Users.CreateUser(&user)
+	if err == storage.ErrConflict {
+		return nil, errors.New("username is already taken")
+	}
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not create user")
+	}
+	return &user, nil
+}
+
+func (service *ChatUserService) ListUsers() ([]models.ChatUser, error) {
+	users, err := service.Users.ListUsers()
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list users")
+	}
+	return users, nil
+}
+
+func (service *ChatUserService) UpdateProfile(user *models.ChatUser, name string) error {
+	if name == "" || len(name) > 255 {
+		return errors.New("name must be 1 to 255 characters")
+	}
+	user.Name = name
+	err := service.Users.UpdateUser(user)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not update profile")
+	}
+	return nil
+}
+
+func (service *ChatUserService) ChangePassword(user *models.ChatUser, oldPwd string, newPwd string) error {
+	if service.AuthUser(user.UserName, oldPwd) == nil {
+		return errors.New("old password is not correct")
+	}
+	return service.ResetPassword(user, newPwd)
+}
+
+// ResetPassword sets the password without asking for the old one.
+func (service *ChatUserService) ResetPassword(user *models.ChatUser, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	if service.UpdatePassword(user.UserName, password) == nil {
+		return errors.New("can not update password")
+	}
+	return nil
+}
+
+func (service *ChatUserService) SetDisabled(user *models.ChatUser, disabled bool) error {
+	if service.IsAdministrator(user) {
+		return errors.New("the administrator can not be disabled")
+	}
+	user.Disabled = disabled
+	err := service.Users.UpdateUser(user)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not update user")
+	}
+	return nil
+}
+
+func (service *ChatUserService) DeleteUser(user *models.ChatUser) error {
+	if service.IsAdministrator(user) {
+		return errors.New("the administrator can not be deleted")
+	}
+	err := service.Users.DeleteUser(user.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not delete user")
+	}
+	return nil
+}
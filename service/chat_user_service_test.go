@@ -0,0 +1,79 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+func newInviteOnlyUsers(t *testing.T) (*ChatUserService, *InviteService) {
+	store := storage.NewMemoryStore()
+	users := &ChatUserService{Users: store, RegistrationMode: RegistrationInviteOnly}
+	servers := &ChatServerService{Servers: store, Rooms: store}
+	permissions := &PermissionService{Roles: store, UserService: users, ChatServerService: servers}
+	invites := &InviteService{
+		Invites:           store,
+		ChatServerService: servers,
+		BanService:        &BanService{Bans: store},
+		Permissions:       permissions,
+	}
+	users.Invites = invites
+	if err := store.CreateServer(&models.ChatServer{Id: 1, Name: "server"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := permissions.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return users, invites
+}
+
+func TestConcurrentRegistrationsRespectMaxUses(t *testing.T) {
+	users, invites := newInviteOnlyUsers(t)
+	invite := models.ChatInvite{ServerId: 1, MaxUses: 2}
+	if err := invites.CreateInvite(&invite); err != nil {
+		t.Fatal(err)
+	}
+
+	var wait sync.WaitGroup
+	var lock sync.Mutex
+	registered := 0
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			_, err := users.Register(fmt.Sprintf("user-%d", i), "secret-password", "", invite.Code)
+			if err == nil {
+				lock.Lock()
+				registered++
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	if registered != invite.MaxUses {
+		t.Fatalf("expected %d registrations, got %d", invite.MaxUses, registered)
+	}
+	accounts, err := users.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != invite.MaxUses {
+		t.Fatalf("expected %d accounts, got %d", invite.MaxUses, len(accounts))
+	}
+	if used := invites.GetInviteByCode(invite.Code); used.Uses != invite.MaxUses {
+		t.Fatalf("expected %d uses, got %d", invite.MaxUses, used.Uses)
+	}
+}
+
+func TestRegistrationWithInvalidInvite(t *testing.T) {
+	users, _ := newInviteOnlyUsers(t)
+	if _, err := users.Register("nobody", "secret-password", "", "not-a-code"); err == nil {
+		t.Fatal("expected the registration to fail")
+	}
+	if users.GetUserByUsername("nobody") != nil {
+		t.Fatal("account was created without an invite")
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+const inviteCodeBytes = 9
+
+var errInviteInvalid = errors.New("invite is not valid")
+
+type InviteService struct {
+	Invites           storage.InviteRepository
+	ChatServerService *ChatServerService
+	BanService        *BanService
+	Permissions       *PermissionService
+}
+
+func (service *InviteService) Init() error {
+	logger.Logger.Info("Init InviteService")
+	return nil
+}
+
+func newInviteCode() (string, error) {
+	data := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (service *InviteService) CreateInvite(invite *models.ChatInvite) error {
+	if invite.MaxUses < 0 {
+		return errors.New("max uses can not be negative")
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not create invite")
+	}
+	invite.Code = code
+	invite.Uses = 0
+	invite.CreateAt = time.Now().UnixNano() / int64(time.Millisecond)
+	err = service.Invites.CreateInvite(invite)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not create invite")
+	}
+	return nil
+}
+
+func (service *InviteService) GetInvite(id int64) *models.ChatInvite {
+	invite, err := service.Invites.GetInvite(id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil
+	}
+	return invite
+}
+
+func (service *InviteService) GetInviteByCode(code string) *models.ChatInvite {
+	invite, err := service.Invites.GetInviteByCode(code)
+	if err != nil {
+		return nil
+	}
+	return invite
+}
+
+func (service *InviteService) ListInvites(serverId int64) ([]models.ChatInvite, error) {
+	invites, err := service.Invites.ListInvites(serverId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list invites")
+	}
+	return invites, nil
+}
+
+// RevokeInvite keeps the invite around for its usage stats but stops it from being redeemed.
+func (service *InviteService) RevokeInvite(id int64) error {
+	err := service.Invites.RevokeInvite(id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not revoke invite")
+	}
+	return nil
+}
+
+func (service *InviteService) ValidateInvite(code string) error {
+	invite := service.GetInviteByCode(code)
+	if invite == nil || !invite.IsValid() {
+		return errInviteInvalid
+	}
+	return nil
+}
+
+func (service *InviteService) RedeemInvite(user *models.ChatUser, code string) error {
+	_, err := service.Redeem(user, code)
+	return err
+}
+
+// Redeem uses up one use of the invite, makes the user a member of the server and grants the
+// role of the invite, unless the user already has a role on the server. Room invites also let
+// the user into the room if it is private.
+func (service *InviteService) Redeem(user *models.ChatUser, code string) (*models.ChatInvite, error) {
+	invite := service.GetInviteByCode(code)
+	if invite == nil || !invite.IsValid() {
+		return nil, errInviteInvalid
+	}
+	room := &models.ChatRoom{ServerId: invite.ServerId}
+	if invite.RoomId != 0 {
+		room.Id = invite.RoomId
+	}
+	if ban := service.BanService.FindActiveBan(user.Id, room); ban != nil {
+		return nil, errors.New("user is banned from the server")
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	used, err := service.Invites.UseInvite(invite, now)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not redeem invite")
+	}
+	if !used {
+		return nil, errInviteInvalid
+	}
+
+	if err = service.ChatServerService.AddMember(invite.ServerId, user.Id); err != nil {
+		return nil, err
+	}
+	if invite.RoomId != 0 {
+		if invite.RoleId != 0 && service.Permissions.getRoomRole(user.Id, invite.RoomId) == nil {
+			if err = service.Permissions.AssignRoomRole(user.Id, invite.RoomId, invite.RoleId); err != nil {
+				return nil, err
+			}
+		}
+		if err = service.ChatServerService.GrantRoomAccess(invite.RoomId, user.Id, invite.CreatedBy); err != nil {
+			return nil, err
+		}
+	} else if service.Permissions.getServerRole(user.Id, invite.ServerId) == nil {
+		roleId := invite.RoleId
+		if roleId == 0 {
+			roleId = service.Permissions.defaultRole().Id
+		}
+		if err = service.Permissions.AssignServerRole(user.Id, invite.ServerId, roleId); err != nil {
+			return nil, err
+		}
+	}
+	logger.Logger.Infof("User '%s' redeemed invite %d of server %d", user.UserName, invite.Id, invite.ServerId)
+	return invite, nil
+}
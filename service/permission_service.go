@@ -0,0 +1,234 @@
+package service
+
+import (
+	"errors"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+)
+
+var defaultRoles = []models.ChatRole{
+	{
+		Id:   models.RoleOwner,
+		Name: "owner",
+		Permissions: models.PermissionConnect | models.PermissionSpeak | models.PermissionModerate |
+			models.PermissionManageRooms | models.PermissionManageServer,
+	},
+	{
+		Id:          models.RoleAdmin,
+		Name:        "admin",
+		Permissions: models.PermissionConnect | models.PermissionSpeak | models.PermissionModerate | models.PermissionManageRooms,
+	},
+	{
+		Id:          models.RoleModerator,
+		Name:        "moderator",
+		Permissions: models.PermissionConnect | models.PermissionSpeak | models.PermissionModerate,
+	},
+	{
+		Id:          models.RoleMember,
+		Name:        "member",
+		Permissions: models.PermissionConnect | models.PermissionSpeak,
+	},
+	{
+		Id:          models.RoleGuest,
+		Name:        "guest",
+		Permissions: models.PermissionConnect,
+	},
+}
+
+type PermissionService struct {
+	Roles             storage.RoleRepository
+	UserService       *ChatUserService
+	ChatServerService *ChatServerService
+	// DefaultRoleId is the role of users that have no role on a server.
+	DefaultRoleId int64
+}
+
+func (service *PermissionService) Init() error {
+	logger.Logger.Info("Init PermissionService")
+	for _, role := range defaultRoles {
+		if service.GetRole(role.Id) != nil {
+			continue
+		}
+		logger.Logger.Infof("Init role '%s'", role.Name)
+		role := role
+		err := service.Roles.CreateRole(&role)
+		if err != nil {
+			return err
+		}
+	}
+
+	admin := service.UserService.GetUserByUsername(DefaultAdminUsername)
+	server := service.ChatServerService.GetServerById(1)
+	if admin != nil && server != nil && service.getServerRole(admin.Id, server.Id) == nil {
+		logger.Logger.Info("Init owner of default server")
+		err := service.ChatServerService.AddMember(server.Id, admin.Id)
+		if err != nil {
+			return err
+		}
+		return service.AssignServerRole(admin.Id, server.Id, models.RoleOwner)
+	}
+	return nil
+}
+
+func (service *PermissionService) GetRole(id int64) *models.ChatRole {
+	role, err := service.Roles.GetRole(id)
+	if err != nil {
+		return nil
+	}
+	return role
+}
+
+func (service *PermissionService) ListRoles() ([]models.ChatRole, error) {
+	roles, err := service.Roles.ListRoles()
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list roles")
+	}
+	return roles, nil
+}
+
+func (service *PermissionService) defaultRole() *models.ChatRole {
+	roleId := service.DefaultRoleId
+	if roleId == 0 {
+		roleId = models.RoleMember
+	}
+	return service.GetRole(roleId)
+}
+
+func (service *PermissionService) getServerRole(userId int64, serverId int64) *models.ChatServerRole {
+	serverRole, err := service.Roles.GetServerRole(userId, serverId)
+	if err != nil {
+		return nil
+	}
+	return serverRole
+}
+
+func (service *PermissionService) getRoomRole(userId int64, roomId int64) *models.ChatRoomRole {
+	roomRole, err := service.Roles.GetRoomRole(userId, roomId)
+	if err != nil {
+		return nil
+	}
+	return roomRole
+}
+
+// RoleOf resolves the effective role of a user: a room override wins over the server role,
+// which wins over the default role.
+func (service *PermissionService) RoleOf(userId int64, serverId int64, roomId int64) *models.ChatRole {
+	if roomId != 0 {
+		if roomRole := service.getRoomRole(userId, roomId); roomRole != nil {
+			return service.GetRole(roomRole.RoleId)
+		}
+	}
+	if serverRole := service.getServerRole(userId, serverId); serverRole != nil {
+		return service.GetRole(serverRole.RoleId)
+	}
+	return service.defaultRole()
+}
+
+// Permissions returns the effective permissions of the user on a server, or on a single
+// room when roomId is not zero. The site administrator holds every permission, users who
+// are not members of the server hold none.
+func (service *PermissionService) Permissions(user *models.ChatUser, serverId int64, roomId int64) models.Permission {
+	if service.UserService.IsAdministrator(user) {
+		return ^models.Permission(0)
+	}
+	if !service.ChatServerService.IsMember(serverId, user.Id) {
+		return 0
+	}
+	role := service.RoleOf(user.Id, serverId, roomId)
+	if role == nil {
+		return 0
+	}
+	return role.Permissions
+}
+
+func (service *PermissionService) HasPermission(user *models.ChatUser, serverId int64, roomId int64, permission models.Permission) bool {
+	return service.Permissions(user, serverId, roomId)&permission == permission
+}
+
+// CanActOn tells whether the actor may moderate the target on a server, or on a single room
+// when roomId is not zero. Nobody acts on the site administrator, the others only on users
+// holding a strict subset of their permissions, so equal ranks can not act on each other.
+func (service *PermissionService) CanActOn(actor *models.ChatUser, targetId int64, serverId int64, roomId int64) bool {
+	if service.UserService.IsAdministrator(service.UserService.GetUserById(targetId)) {
+		return false
+	}
+	if service.UserService.IsAdministrator(actor) {
+		return true
+	}
+	target := models.Permission(0)
+	if role := service.RoleOf(targetId, serverId, roomId); role != nil {
+		target = role.Permissions
+	}
+	permissions := service.Permissions(actor, serverId, roomId)
+	return target&^permissions == 0 && target != permissions
+}
+
+func (service *PermissionService) AssignServerRole(userId int64, serverId int64, roleId int64) error {
+	if service.GetRole(roleId) == nil {
+		return errors.New("can not find role")
+	}
+	err := service.Roles.SetServerRole(userId, serverId, roleId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not assign role")
+	}
+	return nil
+}
+
+func (service *PermissionService) AssignRoomRole(userId int64, roomId int64, roleId int64) error {
+	if service.GetRole(roleId) == nil {
+		return errors.New("can not find role")
+	}
+	err := service.Roles.SetRoomRole(userId, roomId, roleId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not assign role")
+	}
+	return nil
+}
+
+func (service *PermissionService) RemoveRoomRole(userId int64, roomId int64) error {
+	err := service.Roles.DeleteRoomRole(userId, roomId)
+	if err != nil {
+		logger.Logger.Error(err)
+		return errors.New("can not remove role")
+	}
+	return nil
+}
+
+// Authorities lists the effective role of the user on every server it is a member of plus
+// its room overrides.
+func (service *PermissionService) Authorities(user *models.ChatUser) []dto.AuthAuthority {
+	authorities := make([]dto.AuthAuthority, 0)
+	for _, server := range service.ChatServerService.ListServersOf(user.Id) {
+		role := service.RoleOf(user.Id, server.Id, 0)
+		if role == nil {
+			continue
+		}
+		authorities = append(authorities, dto.AuthAuthority{
+			Id:          role.Id,
+			Authority:   role.Name,
+			ServerId:    server.Id,
+			Permissions: role.PermissionNames(),
+		})
+	}
+
+	roomRoles, err := service.Roles.ListRoomRolesOf(user.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return authorities
+	}
+	for _, roomRole := range roomRoles {
+		authorities = append(authorities, dto.AuthAuthority{
+			Id:          roomRole.Role.Id,
+			Authority:   roomRole.Role.Name,
+			ServerId:    roomRole.Room.ServerId,
+			RoomId:      roomRole.RoomId,
+			Permissions: roomRole.Role.PermissionNames(),
+		})
+	}
+	return authorities
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"voice-chat-server/models"
+)
+
+var ErrRoomFull = errors.New("room is full")
+
+type ChatRoomConnectionContext struct {
+	RoomId            int64
+	ServerId          int64
+	MediaMode         string
+	ConnectionManager *ChatRoomConnectionManager
+	lock              sync.RWMutex
+	connections       map[string]*ChatRoomConn
+	// waiting holds the connections queued for a free slot, in arrival order.
+	waiting      []*ChatRoomConn
+	capacity     int
+	maxSpeakers  int
+	waitingQueue bool
+	speakerLock  sync.Mutex
+	mixer        *roomMixer
+	// unmixable is set once a speaker sends a codec the mixer can not decode.
+	unmixable bool
+	stop      chan struct{}
+}
+
+func (context *ChatRoomConnectionContext) Mixer() *roomMixer {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return context.mixer
+}
+
+func (context *ChatRoomConnectionContext) SetMixing(enabled bool) {
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	context.setMixingLocked(enabled)
+}
+
+func (context *ChatRoomConnectionContext) setMixingLocked(enabled bool) {
+	if enabled && context.mixer == nil {
+		manager := context.ConnectionManager
+		context.mixer = newRoomMixer(context, manager.mixerCodecs(), manager.mixerOutputCodec())
+		go context.mixer.run()
+	} else if !enabled && context.mixer != nil {
+		context.mixer.close()
+		context.mixer = nil
+	}
+}
+
+// markUnmixable remembers that the room carries audio the mixer can not decode and switches
+// mixing off for its listeners, which it returns. Only the first call switches listeners off.
+func (context *ChatRoomConnectionContext) markUnmixable() []*ChatRoomConn {
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	if context.unmixable {
+		return nil
+	}
+	context.unmixable = true
+	listeners := make([]*ChatRoomConn, 0)
+	for _, c := range context.connections {
+		if c.WantsMixing() {
+			c.SetMixing(false)
+			listeners = append(listeners, c)
+		}
+	}
+	for _, c := range context.waiting {
+		if c.WantsMixing() {
+			c.SetMixing(false)
+			listeners = append(listeners, c)
+		}
+	}
+	return listeners
+}
+
+// enableMixing switches mixing of the connection on or off, it stays off once the room carries
+// audio the mixer can not decode.
+func (context *ChatRoomConnectionContext) enableMixing(conn *ChatRoomConn, enabled bool) bool {
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	if enabled && context.unmixable {
+		conn.SetMixing(false)
+		return false
+	}
+	conn.SetMixing(enabled)
+	return true
+}
+
+func (context *ChatRoomConnectionContext) Connections() []*ChatRoomConn {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	conns := make([]*ChatRoomConn, 0, len(context.connections))
+	for _, c := range context.connections {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (context *ChatRoomConnectionContext) Connection(id string) *ChatRoomConn {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return context.connections[id]
+}
+
+func (context *ChatRoomConnectionContext) Size() int {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return len(context.connections)
+}
+
+func (context *ChatRoomConnectionContext) Waiting() []*ChatRoomConn {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	conns := make([]*ChatRoomConn, len(context.waiting))
+	copy(conns, context.waiting)
+	return conns
+}
+
+func (context *ChatRoomConnectionContext) Capacity() int {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return context.capacity
+}
+
+func (context *ChatRoomConnectionContext) MaxSpeakers() int {
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return context.maxSpeakers
+}
+
+func (context *ChatRoomConnectionContext) fullLocked() bool {
+	return context.capacity > 0 && len(context.connections) >= context.capacity
+}
+
+func (context *ChatRoomConnectionContext) addLocked(conn *ChatRoomConn) {
+	context.connections[conn.Id] = conn
+	conn.setAdmitted()
+}
+
+// admitLocked moves waiting connections into the room while there are free slots.
+func (context *ChatRoomConnectionContext) admitLocked() []*ChatRoomConn {
+	admitted := make([]*ChatRoomConn, 0)
+	for len(context.waiting) > 0 && !context.fullLocked() {
+		conn := context.waiting[0]
+		context.waiting = context.waiting[1:]
+		context.addLocked(conn)
+		admitted = append(admitted, conn)
+	}
+	return admitted
+}
+
+func (context *ChatRoomConnectionContext) Broadcast(exclude *ChatRoomConn, messageType int, data []byte) {
+	for _, c := range context.Connections() {
+		if c == exclude {
+			continue
+		}
+		_ = c.Send(messageType, data)
+	}
+}
+
+// RoomRegistry keeps the live connections of every room. Rooms are created on the first
+// join and removed once their last connection leaves; both happen under the registry lock
+// so a join can never land in a room that is being removed.
+type RoomRegistry struct {
+	lock    sync.RWMutex
+	rooms   map[int64]*ChatRoomConnectionContext
+	manager *ChatRoomConnectionManager
+}
+
+func NewRoomRegistry(manager *ChatRoomConnectionManager) *RoomRegistry {
+	return &RoomRegistry{
+		rooms:   make(map[int64]*ChatRoomConnectionContext),
+		manager: manager,
+	}
+}
+
+func (registry *RoomRegistry) Get(roomId int64) *ChatRoomConnectionContext {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return registry.rooms[roomId]
+}
+
+func (registry *RoomRegistry) Rooms() []*ChatRoomConnectionContext {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	rooms := make([]*ChatRoomConnectionContext, 0, len(registry.rooms))
+	for _, room := range registry.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+func (registry *RoomRegistry) Find(roomId int64, connId string) *ChatRoomConn {
+	context := registry.Get(roomId)
+	if context == nil {
+		return nil
+	}
+	return context.Connection(connId)
+}
+
+// IsFull tells whether a new connection would have to wait or be rejected. The answer may be
+// stale by the time the connection joins, Join checks again.
+func (registry *RoomRegistry) IsFull(room *models.ChatRoom) bool {
+	context := registry.Get(room.Id)
+	if context == nil {
+		return false
+	}
+	context.lock.RLock()
+	defer context.lock.RUnlock()
+	return context.fullLocked()
+}
+
+// Join adds the connection to the room. When the room is full, the connection is queued if the
+// room has a waiting queue and ErrRoomFull is returned otherwise.
+func (registry *RoomRegistry) Join(room *models.ChatRoom, conn *ChatRoomConn) (*ChatRoomConnectionContext, bool, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	context, ok := registry.rooms[room.Id]
+	if !ok {
+		context = &ChatRoomConnectionContext{
+			RoomId:            room.Id,
+			ServerId:          room.ServerId,
+			MediaMode:         registry.manager.mediaMode(room),
+			ConnectionManager: registry.manager,
+			connections:       make(map[string]*ChatRoomConn),
+			capacity:          room.Capacity,
+			maxSpeakers:       room.MaxSpeakers,
+			waitingQueue:      room.WaitingQueue,
+			stop:              make(chan struct{}),
+		}
+		if room.Mixing {
+			context.setMixingLocked(true)
+		}
+		registry.rooms[room.Id] = context
+		go registry.manager.watchVoiceActivity(context)
+	}
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	queued := false
+	if !context.fullLocked() {
+		context.addLocked(conn)
+	} else if context.waitingQueue {
+		context.waiting = append(context.waiting, conn)
+		queued = true
+	} else {
+		return nil, false, ErrRoomFull
+	}
+	conn.Context = context
+	return context, queued, nil
+}
+
+// Update applies changed room limits to a live room and returns the connections admitted from
+// the waiting queue because of them.
+func (registry *RoomRegistry) Update(room *models.ChatRoom) []*ChatRoomConn {
+	context := registry.Get(room.Id)
+	if context == nil {
+		return nil
+	}
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	context.capacity = room.Capacity
+	context.maxSpeakers = room.MaxSpeakers
+	context.waitingQueue = room.WaitingQueue
+	return context.admitLocked()
+}
+
+// Leave removes the connection from its room, or from the waiting queue of the room, and
+// reports whether it was still registered, so that concurrent callers clean up a connection
+// only once. Connections admitted into the freed slot are returned.
+func (registry *RoomRegistry) Leave(conn *ChatRoomConn) (bool, []*ChatRoomConn) {
+	if conn.Context == nil {
+		return false, nil
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	context, ok := registry.rooms[conn.Context.RoomId]
+	if !ok {
+		return false, nil
+	}
+	context.lock.Lock()
+	defer context.lock.Unlock()
+	for i, c := range context.waiting {
+		if c == conn {
+			context.waiting = append(context.waiting[:i:i], context.waiting[i+1:]...)
+			return true, nil
+		}
+	}
+	if context.connections[conn.Id] != conn {
+		return false, nil
+	}
+	delete(context.connections, conn.Id)
+	if context.mixer != nil {
+		context.mixer.remove(conn.Id)
+	}
+	admitted := context.admitLocked()
+	if len(context.connections) == 0 {
+		context.setMixingLocked(false)
+		close(context.stop)
+		delete(registry.rooms, context.RoomId)
+	}
+	return true, admitted
+}
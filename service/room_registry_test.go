@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"sync"
+	"testing"
+	"voice-chat-server/dto"
+	"voice-chat-server/models"
+)
+
+func newRegistryConn(id int) *ChatRoomConn {
+	return &ChatRoomConn{
+		Id:    fmt.Sprintf("conn-%d", id),
+		User:  &models.ChatUser{Id: int64(id), UserName: fmt.Sprintf("user-%d", id)},
+		stop:  make(chan struct{}),
+		queue: newSendQueue(1024, OverflowDropOldest, DefaultMaxDroppedFrames),
+	}
+}
+
+func TestRoomRegistryConcurrentJoinLeaveBroadcast(t *testing.T) {
+	manager := &ChatRoomConnectionManager{VoiceActivity: DefaultVoiceActivityOptions}
+	registry := NewRoomRegistry(manager)
+	rooms := []*models.ChatRoom{{Id: 1, ServerId: 1}, {Id: 2, ServerId: 1}}
+
+	var wait sync.WaitGroup
+	done := make(chan struct{})
+	// readers iterate over snapshots while the rooms change
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, context := range registry.Rooms() {
+				for _, c := range context.Connections() {
+					registry.Find(context.RoomId, c.Id)
+				}
+				context.Size()
+			}
+		}
+	}()
+	for i := 0; i < 32; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			conn := newRegistryConn(i)
+			room := rooms[i%len(rooms)]
+			for round := 0; round < 5; round++ {
+				context, queued, err := registry.Join(room, conn)
+				if err != nil || queued {
+					t.Errorf("join of %s: queued %v, %v", conn.Id, queued, err)
+					return
+				}
+				context.Broadcast(conn, websocket.TextMessage, []byte(conn.Id))
+				if registry.Find(room.Id, conn.Id) != conn {
+					t.Errorf("%s is not found in room %d", conn.Id, room.Id)
+				}
+				if left, _ := registry.Leave(conn); !left {
+					t.Errorf("%s did not leave room %d", conn.Id, room.Id)
+				}
+				if left, _ := registry.Leave(conn); left {
+					t.Errorf("%s left room %d twice", conn.Id, room.Id)
+				}
+			}
+		}(i)
+	}
+	wait.Wait()
+	close(done)
+
+	// empty rooms are removed
+	if remaining := registry.Rooms(); len(remaining) != 0 {
+		t.Fatalf("expected no rooms, got %d", len(remaining))
+	}
+}
+
+func TestRoomRegistryQueuesWhenFull(t *testing.T) {
+	manager := &ChatRoomConnectionManager{VoiceActivity: DefaultVoiceActivityOptions}
+	registry := NewRoomRegistry(manager)
+	room := &models.ChatRoom{Id: 1, ServerId: 1, Capacity: 4, WaitingQueue: true}
+
+	conns := make([]*ChatRoomConn, 12)
+	var wait sync.WaitGroup
+	for i := range conns {
+		conns[i] = newRegistryConn(i)
+		wait.Add(1)
+		go func(conn *ChatRoomConn) {
+			defer wait.Done()
+			if _, _, err := registry.Join(room, conn); err != nil {
+				t.Error(err)
+			}
+		}(conns[i])
+	}
+	wait.Wait()
+	context := registry.Get(room.Id)
+	if context.Size() != room.Capacity || len(context.Waiting()) != len(conns)-room.Capacity {
+		t.Fatalf("expected %d in the room and %d waiting, got %d and %d", room.Capacity,
+			len(conns)-room.Capacity, context.Size(), len(context.Waiting()))
+	}
+
+	admitted := 0
+	for _, conn := range context.Connections() {
+		wait.Add(1)
+		go func(conn *ChatRoomConn) {
+			defer wait.Done()
+			_, _ = registry.Leave(conn)
+		}(conn)
+	}
+	wait.Wait()
+	for _, conn := range conns {
+		if context.Connection(conn.Id) != nil {
+			admitted++
+		}
+	}
+	if admitted != room.Capacity {
+		t.Fatalf("expected %d admitted from the queue, got %d", room.Capacity, admitted)
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeRelay)
+	listener := rooms.join(t, nil)
+
+	tokens := make([]string, 6)
+	for i := range tokens {
+		_, tokens[i] = rooms.login(t)
+	}
+	var wait sync.WaitGroup
+	for i, token := range tokens {
+		wait.Add(1)
+		go func(i int, token string) {
+			defer wait.Done()
+			conn, err := rooms.dial(1, token, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			msg, err := dto.NewEnvelope(dto.MessageChat, fmt.Sprint(i), dto.ChatPayload{Text: fmt.Sprint(i)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err = conn.WriteJSON(msg); err != nil {
+				t.Error(err)
+			}
+			if err = conn.WriteMessage(websocket.BinaryMessage, opusFrame(uint16(i), "frame").Marshal()); err != nil {
+				t.Error(err)
+			}
+		}(i, token)
+	}
+	wait.Wait()
+
+	// every client joins, talks and leaves again
+	for range tokens {
+		listener.readEnvelope(dto.MessageLeft)
+	}
+	if members := rooms.manager.RoomMembers(1); len(members) != 1 {
+		t.Fatalf("expected only the listener in the room, got %d", len(members))
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync/atomic"
+	"time"
+)
+
+type OverflowPolicy int
+
+const (
+	// OverflowDropOldest discards the oldest queued audio frame to make room for a new one
+	// and disconnects the consumer once it has dropped more than MaxDroppedFrames in a row.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDisconnect disconnects the consumer as soon as its queue is full.
+	OverflowDisconnect
+)
+
+const (
+	DefaultSendQueueSize    = 64
+	DefaultMaxDroppedFrames = 50
+	writeWait               = 10 * time.Second
+)
+
+var errSlowConsumer = errors.New("connection send queue overflowed")
+
+type outboundMessage struct {
+	messageType int
+	data        []byte
+}
+
+type SendQueueStats struct {
+	Depth         int    `json:"depth"`
+	Capacity      int    `json:"capacity"`
+	HighWatermark int    `json:"highWatermark"`
+	Dropped       uint64 `json:"dropped"`
+}
+
+// sendQueue buffers outbound messages of a single connection. Signalling messages and audio
+// frames are queued separately so that dropping stale audio never loses a signalling message.
+type sendQueue struct {
+	messages      chan outboundMessage
+	frames        chan outboundMessage
+	policy        OverflowPolicy
+	maxDrops      int32
+	pendingDrops  int32
+	highWatermark int32
+	dropped       uint64
+}
+
+func newSendQueue(size int, policy OverflowPolicy, maxDrops int) *sendQueue {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	if maxDrops <= 0 {
+		maxDrops = DefaultMaxDroppedFrames
+	}
+	return &sendQueue{
+		messages: make(chan outboundMessage, size),
+		frames:   make(chan outboundMessage, size),
+		policy:   policy,
+		maxDrops: int32(maxDrops),
+	}
+}
+
+func (q *sendQueue) push(msg outboundMessage) error {
+	if msg.messageType != websocket.BinaryMessage {
+		select {
+		case q.messages <- msg:
+			q.updateWatermark()
+			return nil
+		default:
+			return errSlowConsumer
+		}
+	}
+
+	select {
+	case q.frames <- msg:
+		q.updateWatermark()
+		return nil
+	default:
+	}
+	if q.policy == OverflowDisconnect {
+		return errSlowConsumer
+	}
+
+	select {
+	case <-q.frames:
+		q.drop()
+	default:
+	}
+	select {
+	case q.frames <- msg:
+	default:
+		q.drop()
+	}
+	if atomic.LoadInt32(&q.pendingDrops) > q.maxDrops {
+		return errSlowConsumer
+	}
+	return nil
+}
+
+func (q *sendQueue) drop() {
+	atomic.AddUint64(&q.dropped, 1)
+	atomic.AddInt32(&q.pendingDrops, 1)
+}
+
+// drained is called by the writer after each write so that only consecutive drops count
+// towards the disconnect threshold.
+func (q *sendQueue) drained() {
+	if len(q.frames) == 0 {
+		atomic.StoreInt32(&q.pendingDrops, 0)
+	}
+}
+
+func (q *sendQueue) updateWatermark() {
+	depth := int32(q.depth())
+	for {
+		current := atomic.LoadInt32(&q.highWatermark)
+		if depth <= current || atomic.CompareAndSwapInt32(&q.highWatermark, current, depth) {
+			return
+		}
+	}
+}
+
+func (q *sendQueue) depth() int {
+	return len(q.messages) + len(q.frames)
+}
+
+func (q *sendQueue) stats() SendQueueStats {
+	return SendQueueStats{
+		Depth:         q.depth(),
+		Capacity:      cap(q.messages) + cap(q.frames),
+		HighWatermark: int(atomic.LoadInt32(&q.highWatermark)),
+		Dropped:       atomic.LoadUint64(&q.dropped),
+	}
+}
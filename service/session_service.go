@@ -1,36 +1,44 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/go-pg/pg/v9"
-	"github.com/go-pg/pg/v9/orm"
+	"github.com/satori/go.uuid"
 	"net/http"
 	"time"
+	"voice-chat-server/dto"
 	"voice-chat-server/logger"
 	"voice-chat-server/models"
+	"voice-chat-server/storage"
 	"voice-chat-server/utils/auth"
 )
 
-const DefaultExpiration = int64(30 * time.Minute / time.Millisecond)
+// Expirations are in ms. The session of an access token expires together with the token.
+const (
+	DefaultExpiration        = int64(15 * time.Minute / time.Millisecond)
+	DefaultRefreshExpiration = int64(7 * 24 * time.Hour / time.Millisecond)
+)
+
+const refreshTokenBytes = 32
+
+var ErrRefreshTokenInvalid = errors.New("refresh token is not valid")
 
 type SessionService struct {
-	DbService   *DBService
+	Sessions    storage.SessionRepository
 	UserService *ChatUserService
-	ticker      *time.Ticker
+	// Expiration and RefreshExpiration override the defaults when not zero.
+	Expiration        int64
+	RefreshExpiration int64
+	ticker            *time.Ticker
 }
 
 func (service *SessionService) Init() error {
 	logger.Logger.Info("Init SessionService")
-	for _, model := range []interface{}{(*models.UserSession)(nil)} {
-		err := service.DbService.DB.CreateTable(model, &orm.CreateTableOptions{
-			IfNotExists:   true,
-			FKConstraints: true,
-		})
-		if err != nil {
-			return err
-		}
-	}
 	service.startCheckSessionScheduler()
 	return nil
 }
@@ -48,15 +56,17 @@ func (service *SessionService) startCheckSessionScheduler() {
 
 func (service *SessionService) checkSessions() {
 	logger.Logger.Info("Checking sessions' expiration")
-	session := models.UserSession{}
 	now := time.Now().UnixNano() / int64(time.Millisecond)
-	r, err := service.DbService.DB.Model(&session).
-		Where("expires < ?", now).
-		Delete()
+	expired, err := service.Sessions.DeleteExpiredSessions(now)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+	logger.Logger.Info("Expired total", expired)
+
+	err = service.Sessions.DeleteExpiredRefreshTokens(now)
 	if err != nil {
 		logger.Logger.Error(err)
 	}
-	logger.Logger.Info("Expired total", r.RowsAffected())
 }
 
 func (service *SessionService) Close() {
@@ -64,34 +74,132 @@ func (service *SessionService) Close() {
 	logger.Logger.Info("Scheduler of checking session expiration closed")
 }
 
-func (service *SessionService) Create(user *models.ChatUser, token string) *models.UserSession {
-	var session models.UserSession
-	err := service.DbService.DB.RunInTransaction(func(tx *pg.Tx) error {
-		oldSession := service.GetByUserName(user.UserName)
-		if oldSession != nil {
-			err := service.DbService.DB.Delete(oldSession)
-			if err != nil {
-				logger.Logger.Error(err)
-				return err
-			}
-		}
+func (service *SessionService) expiration() int64 {
+	if service.Expiration > 0 {
+		return service.Expiration
+	}
+	return DefaultExpiration
+}
 
-		now := time.Now().UnixNano() / int64(time.Millisecond)
-		session = models.UserSession{
-			UserName: user.UserName,
-			Token:    token,
-			CreateAt: now,
-			Expires:  now + DefaultExpiration,
-		}
+func (service *SessionService) refreshExpiration() int64 {
+	if service.RefreshExpiration > 0 {
+		return service.RefreshExpiration
+	}
+	return DefaultRefreshExpiration
+}
 
-		err := service.DbService.DB.Insert(&session)
+// Login starts a new session of the user, ending the previous one together with its refresh
+// tokens.
+func (service *SessionService) Login(user *models.ChatUser) (*dto.JwtToken, error) {
+	service.revokeRefreshTokens(user.UserName)
+	return service.issue(user, uuid.NewV4().String())
+}
+
+// Refresh trades a refresh token for a new access token and a new refresh token of the same
+// family. Presenting a token that was already used revokes the whole family and ends the
+// session, as either the client or an attacker holds a stolen token.
+func (service *SessionService) Refresh(refreshToken string) (*dto.JwtToken, error) {
+	token, err := service.Sessions.GetRefreshToken(hashToken(refreshToken))
+	if err != nil || token.Revoked || token.IsExpired() {
+		return nil, ErrRefreshTokenInvalid
+	}
+	used, err := service.Sessions.UseRefreshToken(token.Id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, ErrRefreshTokenInvalid
+	}
+	if !used {
+		logger.Logger.Warningf("Refresh token of '%s' reused, revoke token family %s", token.UserName, token.Family)
+		err = service.Sessions.RevokeTokenFamily(token.Family)
 		if err != nil {
 			logger.Logger.Error(err)
-			return err
 		}
-		return nil
+		service.deleteSessions(token.UserName)
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	user := service.UserService.GetUserByUsername(token.UserName)
+	if user == nil || user.Disabled {
+		return nil, ErrRefreshTokenInvalid
+	}
+	return service.issue(user, token.Family)
+}
+
+func (service *SessionService) issue(user *models.ChatUser, family string) (*dto.JwtToken, error) {
+	now := time.Now()
+	expiration := service.expiration()
+	expires := now.UnixNano()/int64(time.Millisecond) + expiration
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := make(jwt.MapClaims)
+	// sub and the random jti keep the tokens of logins within the same second apart
+	claims["sub"] = user.UserName
+	claims["jti"] = uuid.NewV4().String()
+	claims["exp"] = expires / 1000
+	claims["iat"] = now.Unix()
+	token.Claims = claims
+	tokenString, err := token.SignedString([]byte(auth.SecretKey))
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("error while signing the token")
+	}
+	if service.Create(user, tokenString, expires) == nil {
+		return nil, errors.New("can not create session")
+	}
+
+	refreshToken, err := service.createRefreshToken(user.UserName, family)
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not create refresh token")
+	}
+	return &dto.JwtToken{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    expiration / 1000,
+	}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (service *SessionService) createRefreshToken(username string, family string) (string, error) {
+	data := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	err := service.Sessions.CreateRefreshToken(&models.RefreshToken{
+		UserName:  username,
+		Family:    family,
+		TokenHash: hashToken(value),
+		CreateAt:  now,
+		Expires:   now + service.refreshExpiration(),
 	})
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
 
+func (service *SessionService) revokeRefreshTokens(username string) {
+	err := service.Sessions.RevokeRefreshTokens(username)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
+}
+
+func (service *SessionService) Create(user *models.ChatUser, token string, expires int64) *models.UserSession {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	session := models.UserSession{
+		UserName: user.UserName,
+		Token:    token,
+		CreateAt: now,
+		Expires:  expires,
+	}
+	err := service.Sessions.ReplaceSession(&session)
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil
@@ -100,23 +208,67 @@ func (service *SessionService) Create(user *models.ChatUser, token string) *mode
 }
 
 func (service *SessionService) GetByUserName(user string) *models.UserSession {
-	var session models.UserSession
-	err := service.DbService.DB.Model(&session).Where("user_name = ?", user).Select()
+	session, err := service.Sessions.GetSessionByUserName(user)
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil
 	}
-	return &session
+	return session
+}
+
+func (service *SessionService) ListSessions() ([]models.UserSession, error) {
+	sessions, err := service.Sessions.ListSessions()
+	if err != nil {
+		logger.Logger.Error(err)
+		return nil, errors.New("can not list sessions")
+	}
+	return sessions, nil
+}
+
+// DeleteByUserName ends the session of the user and revokes its refresh tokens.
+func (service *SessionService) DeleteByUserName(user string) {
+	service.deleteSessions(user)
+	service.revokeRefreshTokens(user)
+}
+
+func (service *SessionService) deleteSessions(user string) {
+	err := service.Sessions.DeleteSessions(user)
+	if err != nil {
+		logger.Logger.Error(err)
+	}
 }
 
 func (service *SessionService) GetByToken(token string) *models.UserSession {
-	var session models.UserSession
-	err := service.DbService.DB.Model(&session).Where("token = ?", token).Select()
+	session, err := service.Sessions.GetSessionByToken(token)
 	if err != nil {
 		logger.Logger.Error(err)
 		return nil
 	}
-	return &session
+	return session
+}
+
+// sessionOf finds the session of the subject of the token, which must still be the token of
+// that session.
+func (service *SessionService) sessionOf(jwtToken *jwt.Token) *models.UserSession {
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	subject, ok := claims["sub"].(string)
+	if !ok || subject == "" {
+		return nil
+	}
+	session, err := service.Sessions.GetSessionByUserName(subject)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			logger.Logger.Error(err)
+		}
+		return nil
+	}
+	if session.Token != jwtToken.Raw {
+		return nil
+	}
+	return session
 }
 
 func (service *SessionService) GetUserFromRequest(w http.ResponseWriter, r *http.Request) *models.ChatUser {
@@ -136,7 +288,7 @@ func (service *SessionService) GetUserByJwtToken(jwtToken *jwt.Token, w http.Res
 		return nil
 	}
 	logger.Logger.Debug("Found token:", jwtToken.Raw)
-	session := service.GetByToken(jwtToken.Raw)
+	session := service.sessionOf(jwtToken)
 	if session == nil || session.IsExpired() {
 		w.WriteHeader(http.StatusUnauthorized)
 		_, _ = fmt.Fprint(w, "Token expired")
@@ -148,5 +300,10 @@ func (service *SessionService) GetUserByJwtToken(jwtToken *jwt.Token, w http.Res
 		_, _ = fmt.Fprint(w, "User not found")
 		return nil
 	}
+	if user.Disabled {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = fmt.Fprint(w, "User disabled")
+		return nil
+	}
 	return user
 }
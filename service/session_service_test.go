@@ -0,0 +1,75 @@
+package service
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"net/http/httptest"
+	"testing"
+	"voice-chat-server/models"
+	"voice-chat-server/storage"
+	"voice-chat-server/utils/auth"
+)
+
+func parseToken(t *testing.T, token string) *jwt.Token {
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		return []byte(auth.SecretKey), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestLoginsWithinOneSecond(t *testing.T) {
+	store := storage.NewMemoryStore()
+	users := &ChatUserService{Users: store}
+	sessions := &SessionService{Sessions: store, UserService: users}
+
+	var accounts []*models.ChatUser
+	for _, username := range []string{"alice", "bob"} {
+		user, err := users.CreateUser(username, "secret-password", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, user)
+	}
+
+	tokens := make([]string, len(accounts))
+	for i, user := range accounts {
+		token, err := sessions.Login(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[i] = token.Token
+	}
+	if tokens[0] == tokens[1] {
+		t.Fatal("tokens of different users are identical")
+	}
+
+	for i, user := range accounts {
+		found := sessions.GetUserByJwtToken(parseToken(t, tokens[i]), httptest.NewRecorder())
+		if found == nil || found.UserName != user.UserName {
+			t.Fatalf("token of %s resolved to %v", user.UserName, found)
+		}
+	}
+}
+
+func TestTokenOfEndedSession(t *testing.T) {
+	store := storage.NewMemoryStore()
+	users := &ChatUserService{Users: store}
+	sessions := &SessionService{Sessions: store, UserService: users}
+
+	user, err := users.CreateUser("carol", "secret-password", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := sessions.Login(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sessions.Login(user); err != nil {
+		t.Fatal(err)
+	}
+	if found := sessions.GetUserByJwtToken(parseToken(t, first.Token), httptest.NewRecorder()); found != nil {
+		t.Fatal("token of the replaced session is still accepted")
+	}
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"errors"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"sync"
+	"sync/atomic"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+)
+
+type SfuSignalFunc func(messageType string, payload interface{})
+
+type sfuPeer struct {
+	id       string
+	pc       *webrtc.PeerConnection
+	signal   SfuSignalFunc
+	lock     sync.Mutex
+	pending  bool
+	muted    int32
+	deafened bool
+	senders  map[string]*webrtc.RTPSender
+}
+
+type sfuRoom struct {
+	lock   sync.RWMutex
+	peers  map[string]*sfuPeer
+	tracks map[string]*webrtc.TrackLocalStaticRTP
+}
+
+// SelectiveForwardingUnit terminates one peer connection per participant and forwards every
+// incoming audio track to all other participants of the same room. Signalling is done by the
+// caller through Offer, Answer and AddCandidate; outgoing signalling goes through SfuSignalFunc.
+type SelectiveForwardingUnit struct {
+	api    *webrtc.API
+	config webrtc.Configuration
+	lock   sync.Mutex
+	rooms  map[int64]*sfuRoom
+}
+
+func NewSelectiveForwardingUnit(iceServers []string) (*SelectiveForwardingUnit, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	err := mediaEngine.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio)
+	if err != nil {
+		return nil, err
+	}
+	config := webrtc.Configuration{}
+	if len(iceServers) > 0 {
+		config.ICEServers = []webrtc.ICEServer{{URLs: iceServers}}
+	}
+	return &SelectiveForwardingUnit{
+		api:    webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)),
+		config: config,
+		rooms:  make(map[int64]*sfuRoom),
+	}, nil
+}
+
+func (sfu *SelectiveForwardingUnit) room(roomId int64, create bool) *sfuRoom {
+	sfu.lock.Lock()
+	defer sfu.lock.Unlock()
+	room, ok := sfu.rooms[roomId]
+	if !ok && create {
+		room = &sfuRoom{
+			peers:  make(map[string]*sfuPeer),
+			tracks: make(map[string]*webrtc.TrackLocalStaticRTP),
+		}
+		sfu.rooms[roomId] = room
+	}
+	return room
+}
+
+func (sfu *SelectiveForwardingUnit) peer(roomId int64, peerId string) *sfuPeer {
+	room := sfu.room(roomId, false)
+	if room == nil {
+		return nil
+	}
+	room.lock.RLock()
+	defer room.lock.RUnlock()
+	return room.peers[peerId]
+}
+
+func (sfu *SelectiveForwardingUnit) newPeer(roomId int64, room *sfuRoom, peerId string, signal SfuSignalFunc) (*sfuPeer, error) {
+	pc, err := sfu.api.NewPeerConnection(sfu.config)
+	if err != nil {
+		return nil, err
+	}
+	peer := &sfuPeer{
+		id:      peerId,
+		pc:      pc,
+		signal:  signal,
+		senders: make(map[string]*webrtc.RTPSender),
+	}
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		init := candidate.ToJSON()
+		signal(dto.MessageCandidate, dto.IceCandidatePayload{
+			Candidate:        init.Candidate,
+			SdpMid:           init.SDPMid,
+			SdpMLineIndex:    init.SDPMLineIndex,
+			UsernameFragment: init.UsernameFragment,
+		})
+	})
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		sfu.forward(room, peer, remote)
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Logger.Debugf("SFU peer %s in room %d is %s", peerId, roomId, state)
+		if state == webrtc.PeerConnectionStateFailed {
+			sfu.RemovePeer(roomId, peerId)
+		}
+	})
+
+	room.lock.Lock()
+	room.peers[peerId] = peer
+	tracks := make(map[string]*webrtc.TrackLocalStaticRTP)
+	for sourceId, track := range room.tracks {
+		tracks[sourceId] = track
+	}
+	room.lock.Unlock()
+
+	for sourceId, track := range tracks {
+		if err := peer.addTrack(sourceId, track); err != nil {
+			logger.Logger.Error(err)
+		}
+	}
+	peer.lock.Lock()
+	peer.pending = len(peer.senders) > 0
+	peer.lock.Unlock()
+	return peer, nil
+}
+
+// forward publishes a remote track to the room and copies its RTP packets until it ends.
+func (sfu *SelectiveForwardingUnit) forward(room *sfuRoom, source *sfuPeer, remote *webrtc.TrackRemote) {
+	if remote.Kind() != webrtc.RTPCodecTypeAudio {
+		return
+	}
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, "audio", source.id)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+
+	room.lock.Lock()
+	room.tracks[source.id] = local
+	peers := room.otherPeers(source.id)
+	room.lock.Unlock()
+
+	for _, peer := range peers {
+		if err := peer.addTrack(source.id, local); err != nil {
+			logger.Logger.Error(err)
+			continue
+		}
+		peer.negotiate()
+	}
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			if err != io.EOF {
+				logger.Logger.Debugf("SFU track of peer %s ended: %v", source.id, err)
+			}
+			break
+		}
+		if atomic.LoadInt32(&source.muted) == 1 {
+			continue
+		}
+		if err = local.WriteRTP(packet); err != nil && err != io.ErrClosedPipe {
+			logger.Logger.Error(err)
+			break
+		}
+	}
+
+	room.lock.Lock()
+	if room.tracks[source.id] == local {
+		delete(room.tracks, source.id)
+	}
+	peers = room.otherPeers(source.id)
+	room.lock.Unlock()
+	for _, peer := range peers {
+		if peer.removeTrack(source.id) {
+			peer.negotiate()
+		}
+	}
+}
+
+func (room *sfuRoom) otherPeers(peerId string) []*sfuPeer {
+	peers := make([]*sfuPeer, 0, len(room.peers))
+	for id, peer := range room.peers {
+		if id != peerId {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (peer *sfuPeer) addTrack(sourceId string, track *webrtc.TrackLocalStaticRTP) error {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if _, ok := peer.senders[sourceId]; ok {
+		return nil
+	}
+	sender, err := peer.pc.AddTrack(track)
+	if err != nil {
+		return err
+	}
+	if peer.deafened {
+		if err = sender.ReplaceTrack(nil); err != nil {
+			logger.Logger.Error(err)
+		}
+	}
+	peer.senders[sourceId] = sender
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (peer *sfuPeer) removeTrack(sourceId string) bool {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	sender, ok := peer.senders[sourceId]
+	if !ok {
+		return false
+	}
+	delete(peer.senders, sourceId)
+	if err := peer.pc.RemoveTrack(sender); err != nil {
+		logger.Logger.Debug(err)
+	}
+	return true
+}
+
+// negotiate sends a fresh offer to the peer, or remembers to do so once the
+// current offer/answer exchange has completed.
+func (peer *sfuPeer) negotiate() {
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	if peer.pc.SignalingState() != webrtc.SignalingStateStable {
+		peer.pending = true
+		return
+	}
+	peer.pending = false
+	offer, err := peer.pc.CreateOffer(nil)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	if err = peer.pc.SetLocalDescription(offer); err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	peer.signal(dto.MessageOffer, dto.SessionDescriptionPayload{Sdp: offer.SDP})
+}
+
+func (sfu *SelectiveForwardingUnit) Offer(roomId int64, peerId string, signal SfuSignalFunc, sdp string) error {
+	peer := sfu.peer(roomId, peerId)
+	if peer == nil {
+		var err error
+		peer, err = sfu.newPeer(roomId, sfu.room(roomId, true), peerId, signal)
+		if err != nil {
+			return err
+		}
+	}
+
+	peer.lock.Lock()
+	if peer.pc.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		// The client wins an offer collision; our offer is resent once this exchange completes.
+		if err := peer.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			peer.lock.Unlock()
+			return err
+		}
+		peer.pending = true
+	}
+	err := peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp})
+	if err != nil {
+		peer.lock.Unlock()
+		return err
+	}
+	answer, err := peer.pc.CreateAnswer(nil)
+	if err != nil {
+		peer.lock.Unlock()
+		return err
+	}
+	if err = peer.pc.SetLocalDescription(answer); err != nil {
+		peer.lock.Unlock()
+		return err
+	}
+	peer.signal(dto.MessageAnswer, dto.SessionDescriptionPayload{Sdp: answer.SDP})
+	pending := peer.pending
+	peer.lock.Unlock()
+
+	if pending {
+		peer.negotiate()
+	}
+	return nil
+}
+
+func (sfu *SelectiveForwardingUnit) Answer(roomId int64, peerId string, sdp string) error {
+	peer := sfu.peer(roomId, peerId)
+	if peer == nil {
+		return errors.New("no peer connection to answer")
+	}
+	peer.lock.Lock()
+	err := peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+	pending := peer.pending
+	peer.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if pending {
+		peer.negotiate()
+	}
+	return nil
+}
+
+func (sfu *SelectiveForwardingUnit) AddCandidate(roomId int64, peerId string, candidate dto.IceCandidatePayload) error {
+	peer := sfu.peer(roomId, peerId)
+	if peer == nil {
+		return errors.New("no peer connection for candidate")
+	}
+	return peer.pc.AddICECandidate(webrtc.ICECandidateInit{
+		Candidate:        candidate.Candidate,
+		SDPMid:           candidate.SdpMid,
+		SDPMLineIndex:    candidate.SdpMLineIndex,
+		UsernameFragment: candidate.UsernameFragment,
+	})
+}
+
+// SetMuted stops forwarding the audio of a peer without renegotiating.
+func (sfu *SelectiveForwardingUnit) SetMuted(roomId int64, peerId string, muted bool) {
+	peer := sfu.peer(roomId, peerId)
+	if peer == nil {
+		return
+	}
+	value := int32(0)
+	if muted {
+		value = 1
+	}
+	atomic.StoreInt32(&peer.muted, value)
+}
+
+// SetDeafened detaches or reattaches the tracks a peer receives without renegotiating.
+func (sfu *SelectiveForwardingUnit) SetDeafened(roomId int64, peerId string, deafened bool) {
+	room := sfu.room(roomId, false)
+	peer := sfu.peer(roomId, peerId)
+	if room == nil || peer == nil {
+		return
+	}
+	room.lock.RLock()
+	tracks := make(map[string]*webrtc.TrackLocalStaticRTP)
+	for sourceId, track := range room.tracks {
+		tracks[sourceId] = track
+	}
+	room.lock.RUnlock()
+
+	peer.lock.Lock()
+	defer peer.lock.Unlock()
+	peer.deafened = deafened
+	for sourceId, sender := range peer.senders {
+		var err error
+		if deafened {
+			err = sender.ReplaceTrack(nil)
+		} else if track, ok := tracks[sourceId]; ok {
+			err = sender.ReplaceTrack(track)
+		}
+		if err != nil {
+			logger.Logger.Error(err)
+		}
+	}
+}
+
+func (sfu *SelectiveForwardingUnit) RemovePeer(roomId int64, peerId string) {
+	room := sfu.room(roomId, false)
+	if room == nil {
+		return
+	}
+	room.lock.Lock()
+	peer, ok := room.peers[peerId]
+	delete(room.peers, peerId)
+	room.lock.Unlock()
+	if !ok {
+		return
+	}
+	if err := peer.pc.Close(); err != nil {
+		logger.Logger.Error(err)
+	}
+
+	sfu.lock.Lock()
+	room.lock.RLock()
+	if len(room.peers) == 0 {
+		delete(sfu.rooms, roomId)
+	}
+	room.lock.RUnlock()
+	sfu.lock.Unlock()
+}
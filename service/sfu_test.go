@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"testing"
+	"time"
+	"voice-chat-server/dto"
+)
+
+type sfuSignal struct {
+	messageType string
+	payload     interface{}
+}
+
+// testPeer is a client of the SFU. Signalling from the SFU is handled in order on its own
+// goroutine, as the SFU signals while holding the lock of the peer.
+type testPeer struct {
+	t          *testing.T
+	id         string
+	sfu        *SelectiveForwardingUnit
+	pc         *webrtc.PeerConnection
+	signals    chan sfuSignal
+	candidates []webrtc.ICECandidateInit
+}
+
+func newTestPeer(t *testing.T, sfu *SelectiveForwardingUnit, id string) *testPeer {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &testPeer{t: t, id: id, sfu: sfu, pc: pc, signals: make(chan sfuSignal, 64)}
+	t.Cleanup(func() {
+		sfu.RemovePeer(1, id)
+		_ = pc.Close()
+	})
+	go peer.handleSignals()
+	return peer
+}
+
+func (peer *testPeer) signal(messageType string, payload interface{}) {
+	peer.signals <- sfuSignal{messageType: messageType, payload: payload}
+}
+
+func (peer *testPeer) handleSignals() {
+	for signal := range peer.signals {
+		var err error
+		switch payload := signal.payload.(type) {
+		case dto.SessionDescriptionPayload:
+			err = peer.handleDescription(signal.messageType, payload.Sdp)
+		case dto.IceCandidatePayload:
+			candidate := webrtc.ICECandidateInit{
+				Candidate:        payload.Candidate,
+				SDPMid:           payload.SdpMid,
+				SDPMLineIndex:    payload.SdpMLineIndex,
+				UsernameFragment: payload.UsernameFragment,
+			}
+			// candidates may overtake the description they belong to
+			if peer.pc.RemoteDescription() == nil {
+				peer.candidates = append(peer.candidates, candidate)
+			} else {
+				err = peer.pc.AddICECandidate(candidate)
+			}
+		}
+		if err != nil {
+			peer.t.Errorf("peer %s: %s: %v", peer.id, signal.messageType, err)
+		}
+	}
+}
+
+func (peer *testPeer) handleDescription(messageType string, sdp string) error {
+	if messageType == dto.MessageAnswer {
+		err := peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+		if err != nil {
+			return err
+		}
+		return peer.addCandidates()
+	}
+	err := peer.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp})
+	if err != nil {
+		return err
+	}
+	if err = peer.addCandidates(); err != nil {
+		return err
+	}
+	answer, err := peer.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(peer.pc)
+	if err = peer.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	<-gathered
+	return peer.sfu.Answer(1, peer.id, peer.pc.LocalDescription().SDP)
+}
+
+func (peer *testPeer) addCandidates() error {
+	for _, candidate := range peer.candidates {
+		if err := peer.pc.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+	peer.candidates = nil
+	return nil
+}
+
+// connect sends an offer holding every candidate of the client to the SFU.
+func (peer *testPeer) connect() {
+	offer, err := peer.pc.CreateOffer(nil)
+	if err != nil {
+		peer.t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(peer.pc)
+	if err = peer.pc.SetLocalDescription(offer); err != nil {
+		peer.t.Fatal(err)
+	}
+	<-gathered
+	if err = peer.sfu.Offer(1, peer.id, peer.signal, peer.pc.LocalDescription().SDP); err != nil {
+		peer.t.Fatal(err)
+	}
+}
+
+func TestSfuForwardsAudioBetweenPeers(t *testing.T) {
+	sfu, err := NewSelectiveForwardingUnit(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	speaker := newTestPeer(t, sfu, "speaker")
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "speaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = speaker.pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	speaker.connect()
+
+	// a synthetic opus packet, forwarded untouched
+	packet := []byte{0xfc, 0xff, 0xfe, 0x01, 0x02, 0x03}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = track.WriteSample(media.Sample{Data: packet, Duration: 20 * time.Millisecond})
+			}
+		}
+	}()
+
+	listener := newTestPeer(t, sfu, "listener")
+	if _, err = listener.pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+	listener.pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		for {
+			rtp, _, err := remote.ReadRTP()
+			if err != nil {
+				return
+			}
+			if len(rtp.Payload) > 0 {
+				select {
+				case received <- rtp.Payload:
+				default:
+				}
+			}
+		}
+	})
+	listener.connect()
+
+	select {
+	case payload := <-received:
+		if !bytes.Equal(payload, packet) {
+			t.Fatalf("expected %x, got %x", packet, payload)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("listener got no audio from the speaker")
+	}
+}
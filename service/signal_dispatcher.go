@@ -0,0 +1,356 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"strings"
+	"time"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+)
+
+const maxChatMessageLength = 2000
+
+type SignalError struct {
+	Code    string
+	Message string
+}
+
+func (err *SignalError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Code, err.Message)
+}
+
+func badRequest(format string, args ...interface{}) *SignalError {
+	return &SignalError{Code: dto.ErrorBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+type signalHandler func(conn *ChatRoomConn, msg *dto.Envelope) error
+
+func (manager *ChatRoomConnectionManager) registerSignalHandlers() {
+	manager.handlers = map[string]signalHandler{
+		dto.MessageJoin:      manager.handleJoin,
+		dto.MessageLeave:     manager.handleLeave,
+		dto.MessageMute:      manager.handleSelfVoiceState,
+		dto.MessageUnmute:    manager.handleSelfVoiceState,
+		dto.MessageDeafen:    manager.handleSelfVoiceState,
+		dto.MessageUndeafen:  manager.handleSelfVoiceState,
+		dto.MessageSpeaking:  manager.handleSpeaking,
+		dto.MessageChat:      manager.handleChat,
+		dto.MessagePing:      manager.handlePing,
+		dto.MessagePong:      manager.handlePong,
+		dto.MessageError:     manager.handleClientError,
+		dto.MessageRoomState: manager.handleRoomState,
+		dto.MessageOffer:     manager.handleOffer,
+		dto.MessageAnswer:    manager.handleAnswer,
+		dto.MessageCandidate: manager.handleCandidate,
+		dto.MessageMixing:    manager.handleMixing,
+
+		dto.MessageForceMute:     manager.handleForceVoiceState,
+		dto.MessageForceUnmute:   manager.handleForceVoiceState,
+		dto.MessageForceDeafen:   manager.handleForceVoiceState,
+		dto.MessageForceUndeafen: manager.handleForceVoiceState,
+	}
+}
+
+func (manager *ChatRoomConnectionManager) handleTextMessage(conn *ChatRoomConn, r io.Reader) {
+	var msg dto.Envelope
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		manager.sendError(conn, "", badRequest("malformed message: %v", err))
+		return
+	}
+	manager.dispatch(conn, &msg)
+}
+
+func (manager *ChatRoomConnectionManager) dispatch(conn *ChatRoomConn, msg *dto.Envelope) {
+	if msg.Version != dto.ProtocolVersion {
+		manager.sendError(conn, msg.Id, &SignalError{
+			Code:    dto.ErrorUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d is not supported", msg.Version),
+		})
+		return
+	}
+	handler, ok := manager.handlers[msg.Type]
+	if !ok {
+		manager.sendError(conn, msg.Id, &SignalError{
+			Code:    dto.ErrorUnknownType,
+			Message: fmt.Sprintf("unknown message type '%s'", msg.Type),
+		})
+		return
+	}
+	if err := handler(conn, msg); err != nil {
+		manager.sendError(conn, msg.Id, err)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) send(conn *ChatRoomConn, messageType string, id string, payload interface{}) {
+	envelope, err := dto.NewEnvelope(messageType, id, payload)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	if err = conn.SendJSON(envelope); err != nil {
+		logger.Logger.Debug("Failed to send message to connection", conn.Id, err)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) broadcast(context *ChatRoomConnectionContext, exclude *ChatRoomConn,
+	messageType string, id string, payload interface{}) {
+	envelope, err := dto.NewEnvelope(messageType, id, payload)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	manager.broadcastEnvelope(context, exclude, envelope)
+}
+
+// relay forwards a client message to the rest of the room. The sender is always taken from the
+// authenticated connection, never from what the client put into the envelope.
+func (manager *ChatRoomConnectionManager) relay(conn *ChatRoomConn, messageType string, id string, payload interface{}) {
+	envelope, err := dto.NewEnvelope(messageType, id, payload)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	envelope.From = conn.Sender()
+	manager.broadcastEnvelope(conn.Context, conn, envelope)
+}
+
+func (manager *ChatRoomConnectionManager) broadcastEnvelope(context *ChatRoomConnectionContext, exclude *ChatRoomConn,
+	envelope *dto.Envelope) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	context.Broadcast(exclude, websocket.TextMessage, data)
+}
+
+func (manager *ChatRoomConnectionManager) sendError(conn *ChatRoomConn, id string, err error) {
+	signalErr, ok := err.(*SignalError)
+	if !ok {
+		logger.Logger.Error(err)
+		signalErr = &SignalError{Code: dto.ErrorInternal, Message: "internal server error"}
+	}
+	manager.send(conn, dto.MessageError, id, dto.ErrorPayload{
+		Code:    signalErr.Code,
+		Message: signalErr.Message,
+	})
+}
+
+func (manager *ChatRoomConnectionManager) roomState(context *ChatRoomConnectionContext) dto.RoomStatePayload {
+	state := dto.RoomStatePayload{
+		RoomId:      context.RoomId,
+		MediaMode:   context.MediaMode,
+		Mixing:      context.Mixer() != nil,
+		Capacity:    context.Capacity(),
+		MaxSpeakers: context.MaxSpeakers(),
+		Members:     make([]dto.RoomMember, 0),
+	}
+	for _, c := range context.Connections() {
+		state.Members = append(state.Members, c.Member())
+	}
+	return state
+}
+
+func (manager *ChatRoomConnectionManager) handleJoin(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.JoinPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid join payload: %v", err)
+	}
+	if payload.RoomId != conn.Context.RoomId {
+		return badRequest("connection is bound to room %d, reconnect to join another room", conn.Context.RoomId)
+	}
+	manager.send(conn, dto.MessageRoomState, msg.Id, manager.roomState(conn.Context))
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleLeave(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.LeavePayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid leave payload: %v", err)
+	}
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, payload.Reason)
+	_ = conn.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	_ = conn.Close()
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleSpeaking(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.SpeakingPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid speaking payload: %v", err)
+	}
+	manager.relay(conn, msg.Type, msg.Id, payload)
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleChat(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.ChatPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid chat payload: %v", err)
+	}
+	if strings.TrimSpace(payload.Text) == "" {
+		return badRequest("chat text is empty")
+	}
+	if len(payload.Text) > maxChatMessageLength {
+		return badRequest("chat text is longer than %d bytes", maxChatMessageLength)
+	}
+	manager.relay(conn, msg.Type, msg.Id, payload)
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handlePing(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.PingPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid ping payload: %v", err)
+	}
+	manager.send(conn, dto.MessagePong, msg.Id, payload)
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handlePong(conn *ChatRoomConn, msg *dto.Envelope) error {
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleClientError(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.ErrorPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid error payload: %v", err)
+	}
+	logger.Logger.Warningf("Connection %s reported error %s: %s", conn.Id, payload.Code, payload.Message)
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleRoomState(conn *ChatRoomConn, msg *dto.Envelope) error {
+	manager.send(conn, dto.MessageRoomState, msg.Id, manager.roomState(conn.Context))
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) sfuSignal(conn *ChatRoomConn) SfuSignalFunc {
+	return func(messageType string, payload interface{}) {
+		manager.send(conn, messageType, "", payload)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) webRtcUnsupported(conn *ChatRoomConn) error {
+	return &SignalError{
+		Code:    dto.ErrorUnsupported,
+		Message: fmt.Sprintf("room %d does not use WebRTC", conn.Context.RoomId),
+	}
+}
+
+func negotiationFailed(err error) *SignalError {
+	return &SignalError{Code: dto.ErrorNegotiation, Message: err.Error()}
+}
+
+// sendToPeer routes a mesh signalling message to a single connection of the sender's room.
+func (manager *ChatRoomConnectionManager) sendToPeer(conn *ChatRoomConn, target string, msg *dto.Envelope, payload interface{}) error {
+	if target == "" {
+		return badRequest("%s needs a target connection in mesh mode", msg.Type)
+	}
+	peer := conn.Context.Connection(target)
+	if peer == nil || peer == conn {
+		return &SignalError{
+			Code:    dto.ErrorTargetNotFound,
+			Message: fmt.Sprintf("connection %s is not in room %d", target, conn.Context.RoomId),
+		}
+	}
+	envelope, err := dto.NewEnvelope(msg.Type, msg.Id, payload)
+	if err != nil {
+		return err
+	}
+	envelope.From = conn.Sender()
+	return peer.SendJSON(envelope)
+}
+
+func (manager *ChatRoomConnectionManager) handleOffer(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.SessionDescriptionPayload
+	if err := msg.DecodePayload(&payload); err != nil || payload.Sdp == "" {
+		return badRequest("invalid offer payload")
+	}
+	switch {
+	case conn.Context.MediaMode == MediaModeMesh:
+		return manager.sendToPeer(conn, payload.Target, msg, payload)
+	case conn.Context.MediaMode == MediaModeSfu && manager.Sfu != nil:
+		if err := manager.Sfu.Offer(conn.Context.RoomId, conn.Id, manager.sfuSignal(conn), payload.Sdp); err != nil {
+			return negotiationFailed(err)
+		}
+		state := conn.VoiceState()
+		manager.Sfu.SetMuted(conn.Context.RoomId, conn.Id, !conn.CanSpeak())
+		if state.Deafened() {
+			manager.Sfu.SetDeafened(conn.Context.RoomId, conn.Id, true)
+		}
+		return nil
+	default:
+		return manager.webRtcUnsupported(conn)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) handleAnswer(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.SessionDescriptionPayload
+	if err := msg.DecodePayload(&payload); err != nil || payload.Sdp == "" {
+		return badRequest("invalid answer payload")
+	}
+	switch {
+	case conn.Context.MediaMode == MediaModeMesh:
+		return manager.sendToPeer(conn, payload.Target, msg, payload)
+	case conn.Context.MediaMode == MediaModeSfu && manager.Sfu != nil:
+		if err := manager.Sfu.Answer(conn.Context.RoomId, conn.Id, payload.Sdp); err != nil {
+			return negotiationFailed(err)
+		}
+		return nil
+	default:
+		return manager.webRtcUnsupported(conn)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) handleCandidate(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.IceCandidatePayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid ice candidate payload: %v", err)
+	}
+	switch {
+	case conn.Context.MediaMode == MediaModeMesh:
+		return manager.sendToPeer(conn, payload.Target, msg, payload)
+	case conn.Context.MediaMode == MediaModeSfu && manager.Sfu != nil:
+		if err := manager.Sfu.AddCandidate(conn.Context.RoomId, conn.Id, payload); err != nil {
+			return negotiationFailed(err)
+		}
+		return nil
+	default:
+		return manager.webRtcUnsupported(conn)
+	}
+}
+
+func (manager *ChatRoomConnectionManager) handleMixing(conn *ChatRoomConn, msg *dto.Envelope) error {
+	var payload dto.MixingPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid mixing payload: %v", err)
+	}
+	if conn.Context.MediaMode != MediaModeRelay {
+		return &SignalError{
+			Code:    dto.ErrorUnsupported,
+			Message: fmt.Sprintf("room %d does not relay audio through the server", conn.Context.RoomId),
+		}
+	}
+	if payload.Room {
+		if !manager.canModerate(conn) {
+			return forbidden("only moderators can switch room mixing")
+		}
+		conn.Context.SetMixing(payload.Enabled)
+		envelope, err := dto.NewEnvelope(msg.Type, msg.Id, payload)
+		if err != nil {
+			return err
+		}
+		envelope.From = conn.Sender()
+		manager.broadcastEnvelope(conn.Context, nil, envelope)
+		return nil
+	}
+	if !conn.Context.enableMixing(conn, payload.Enabled) {
+		return unmixable(conn.Context)
+	}
+	manager.send(conn, msg.Type, msg.Id, payload)
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"testing"
+	"voice-chat-server/dto"
+	"voice-chat-server/models"
+)
+
+// expectNothing fails when the client got a signalling message before the answer to a ping.
+func (client *testClient) expectNothing(ignored ...string) {
+	client.send(dto.MessagePing, "nothing", dto.PingPayload{})
+	for {
+		kind, data := client.read()
+		if kind != websocket.TextMessage {
+			continue
+		}
+		var msg dto.Envelope
+		if err := json.Unmarshal(data, &msg); err != nil {
+			client.t.Fatal(err)
+		}
+		if msg.Type == dto.MessagePong && msg.Id == "nothing" {
+			return
+		}
+		skip := false
+		for _, messageType := range ignored {
+			skip = skip || msg.Type == messageType
+		}
+		if !skip {
+			client.t.Fatalf("unexpected %s from %v", msg.Type, msg.From)
+		}
+	}
+}
+
+func (client *testClient) expectError(code string) {
+	var payload dto.ErrorPayload
+	client.readPayload(dto.MessageError, &payload)
+	if payload.Code != code {
+		client.t.Fatalf("expected error %s, got %s: %s", code, payload.Code, payload.Message)
+	}
+}
+
+func TestMeshRoutesSignalsToTheTarget(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeMesh)
+	alice := rooms.join(t, nil)
+	bob := rooms.join(t, nil)
+	carol := rooms.join(t, nil)
+
+	alice.send(dto.MessageOffer, "offer", dto.SessionDescriptionPayload{Sdp: "offer sdp", Target: bob.member.ConnectionId})
+	msg := bob.readEnvelope(dto.MessageOffer)
+	var offer dto.SessionDescriptionPayload
+	if err := json.Unmarshal(msg.Payload, &offer); err != nil {
+		t.Fatal(err)
+	}
+	if offer.Sdp != "offer sdp" || msg.From == nil || msg.From.ConnectionId != alice.member.ConnectionId {
+		t.Fatalf("unexpected offer %+v from %+v", offer, msg.From)
+	}
+
+	bob.send(dto.MessageAnswer, "answer", dto.SessionDescriptionPayload{Sdp: "answer sdp", Target: alice.member.ConnectionId})
+	msg = alice.readEnvelope(dto.MessageAnswer)
+	if msg.From == nil || msg.From.ConnectionId != bob.member.ConnectionId {
+		t.Fatalf("answer is not from bob: %+v", msg.From)
+	}
+
+	bob.send(dto.MessageCandidate, "candidate", dto.IceCandidatePayload{Candidate: "candidate:1", Target: alice.member.ConnectionId})
+	var candidate dto.IceCandidatePayload
+	alice.readPayload(dto.MessageCandidate, &candidate)
+	if candidate.Candidate != "candidate:1" {
+		t.Fatalf("unexpected candidate %+v", candidate)
+	}
+
+	// nothing of it reaches the rest of the room
+	carol.expectNothing(dto.MessageJoined, dto.MessageActiveSpeakers)
+}
+
+func TestMeshValidatesTheTarget(t *testing.T) {
+	rooms := newTestRooms(t, MediaModeMesh)
+	err := rooms.servers.Rooms.CreateRoom(&models.ChatRoom{Id: 2, Name: "other", Description: "other", ServerId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := rooms.join(t, nil)
+	_, token := rooms.login(t)
+	conn, err := rooms.dial(2, token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger := &testClient{Conn: conn, t: t}
+	defer conn.Close()
+	var state dto.RoomStatePayload
+	stranger.readPayload(dto.MessageRoomState, &state)
+
+	alice.send(dto.MessageOffer, "no target", dto.SessionDescriptionPayload{Sdp: "sdp"})
+	alice.expectError(dto.ErrorBadRequest)
+	alice.send(dto.MessageOffer, "self", dto.SessionDescriptionPayload{Sdp: "sdp", Target: alice.member.ConnectionId})
+	alice.expectError(dto.ErrorTargetNotFound)
+	alice.send(dto.MessageOffer, "other room", dto.SessionDescriptionPayload{Sdp: "sdp", Target: state.Members[0].ConnectionId})
+	alice.expectError(dto.ErrorTargetNotFound)
+	alice.send(dto.MessageCandidate, "unknown", dto.IceCandidatePayload{Candidate: "candidate:1", Target: "unknown"})
+	alice.expectError(dto.ErrorTargetNotFound)
+
+	stranger.expectNothing(dto.MessageActiveSpeakers)
+}
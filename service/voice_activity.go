@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"voice-chat-server/dto"
+)
+
+const voiceActivityCheckInterval = 100 * time.Millisecond
+
+// Levels are in -dBov as carried by audio frames, so a lower value is a louder frame.
+// Speaking starts after StartFrames consecutive frames at or below StartLevel and stops once
+// no frame at or below StopLevel has arrived for Hangover.
+type VoiceActivityOptions struct {
+	StartLevel     uint8
+	StopLevel      uint8
+	StartFrames    int
+	Hangover       time.Duration
+	ReportInterval time.Duration
+}
+
+var DefaultVoiceActivityOptions = VoiceActivityOptions{
+	StartLevel:     50,
+	StopLevel:      60,
+	StartFrames:    3,
+	Hangover:       500 * time.Millisecond,
+	ReportInterval: time.Second,
+}
+
+type voiceActivity struct {
+	lock       sync.Mutex
+	speaking   bool
+	loudFrames int
+	lastVoice  time.Time
+	level      uint8
+	// lastFrame and limited track the speaker limit of the room, which counts every relayed
+	// frame regardless of its level or codec.
+	lastFrame time.Time
+	limited   bool
+}
+
+// update feeds the level of a new frame and reports whether the speaking state changed.
+func (activity *voiceActivity) update(level uint8, now time.Time, options *VoiceActivityOptions) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	activity.level = level
+	if level <= options.StopLevel {
+		activity.lastVoice = now
+	}
+	if level <= options.StartLevel {
+		activity.loudFrames++
+	} else {
+		activity.loudFrames = 0
+	}
+	if !activity.speaking && activity.loudFrames >= options.StartFrames {
+		activity.speaking = true
+		return true
+	}
+	return activity.expireLocked(now, options)
+}
+
+func (activity *voiceActivity) expire(now time.Time, options *VoiceActivityOptions) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	return activity.expireLocked(now, options)
+}
+
+func (activity *voiceActivity) expireLocked(now time.Time, options *VoiceActivityOptions) bool {
+	if activity.speaking && now.Sub(activity.lastVoice) > options.Hangover {
+		activity.speaking = false
+		activity.loudFrames = 0
+		activity.level = dto.AudioLevelSilence
+		return true
+	}
+	return false
+}
+
+func (activity *voiceActivity) state() (bool, uint8) {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	return activity.speaking, activity.level
+}
+
+func (activity *voiceActivity) transmitting(now time.Time, hangover time.Duration) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	return now.Sub(activity.lastFrame) <= hangover
+}
+
+func (activity *voiceActivity) touch(now time.Time) {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	activity.lastFrame = now
+}
+
+// limit records whether the speaker limit holds the connection back and reports a change.
+func (activity *voiceActivity) limit(limited bool) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+	changed := activity.limited != limited
+	activity.limited = limited
+	return changed
+}
+
+// PcmLevel returns the RMS level of 16 bit PCM samples in -dBov.
+func PcmLevel(pcm []int16) uint8 {
+	if len(pcm) == 0 {
+		return dto.AudioLevelSilence
+	}
+	sum := float64(0)
+	for _, sample := range pcm {
+		value := float64(sample) / 32768
+		sum += value * value
+	}
+	rms := math.Sqrt(sum / float64(len(pcm)))
+	if rms == 0 {
+		return dto.AudioLevelSilence
+	}
+	level := -20 * math.Log10(rms)
+	if level < 0 {
+		return 0
+	}
+	if level > dto.AudioLevelSilence {
+		return dto.AudioLevelSilence
+	}
+	return uint8(level)
+}
+
+func (manager *ChatRoomConnectionManager) voiceActivityOptions() *VoiceActivityOptions {
+	if manager.VoiceActivity.StartFrames > 0 {
+		return &manager.VoiceActivity
+	}
+	return &DefaultVoiceActivityOptions
+}
+
+// frameLevel prefers the level reported by the client and falls back to measuring PCM frames.
+func (manager *ChatRoomConnectionManager) frameLevel(frame *dto.AudioFrame) (uint8, bool) {
+	if frame.HasLevel() {
+		return frame.Level, true
+	}
+	if frame.Codec() == dto.AudioCodecPcm16 {
+		pcm, err := pcm16Codec{}.Decode(frame.Payload)
+		if err == nil {
+			return PcmLevel(pcm), true
+		}
+	}
+	return 0, false
+}
+
+func (manager *ChatRoomConnectionManager) detectVoiceActivity(conn *ChatRoomConn, frame *dto.AudioFrame) {
+	level, ok := manager.frameLevel(frame)
+	if !ok {
+		return
+	}
+	if conn.activity.update(level, time.Now(), manager.voiceActivityOptions()) {
+		manager.notifySpeaking(conn)
+	}
+}
+
+// admitSpeaker enforces the maximum number of concurrent speakers of the room. A connection
+// keeps its turn as long as its frames arrive within the hangover.
+func (manager *ChatRoomConnectionManager) admitSpeaker(conn *ChatRoomConn, now time.Time) bool {
+	context := conn.Context
+	maxSpeakers := context.MaxSpeakers()
+	if maxSpeakers <= 0 {
+		return true
+	}
+	hangover := manager.voiceActivityOptions().Hangover
+	context.speakerLock.Lock()
+	defer context.speakerLock.Unlock()
+	if !conn.activity.transmitting(now, hangover) {
+		talking := 0
+		for _, c := range context.Connections() {
+			if c != conn && c.activity.transmitting(now, hangover) {
+				talking++
+			}
+		}
+		if talking >= maxSpeakers {
+			if conn.activity.limit(true) {
+				manager.sendError(conn, "", &SignalError{
+					Code:    dto.ErrorSpeakerLimit,
+					Message: fmt.Sprintf("room %d allows %d speakers at once", context.RoomId, maxSpeakers),
+				})
+			}
+			return false
+		}
+	}
+	conn.activity.limit(false)
+	conn.activity.touch(now)
+	return true
+}
+
+func (manager *ChatRoomConnectionManager) notifySpeaking(conn *ChatRoomConn) {
+	speaking, level := conn.activity.state()
+	messageType := dto.MessageSpeakingStopped
+	if speaking {
+		messageType = dto.MessageSpeakingStarted
+	}
+	envelope, err := dto.NewEnvelope(messageType, "", dto.SpeakingPayload{Speaking: speaking, Level: level})
+	if err != nil {
+		return
+	}
+	envelope.From = conn.Sender()
+	manager.broadcastEnvelope(conn.Context, nil, envelope)
+}
+
+// watchVoiceActivity expires speakers that went silent and periodically reports the active
+// speakers of the room until the room is removed.
+func (manager *ChatRoomConnectionManager) watchVoiceActivity(context *ChatRoomConnectionContext) {
+	options := manager.voiceActivityOptions()
+	ticker := time.NewTicker(voiceActivityCheckInterval)
+	defer ticker.Stop()
+	lastReport := time.Now()
+	reported := false
+	for {
+		select {
+		case <-context.stop:
+			return
+		case now := <-ticker.C:
+			speakers := make([]dto.ActiveSpeaker, 0)
+			for _, c := range context.Connections() {
+				if c.activity.expire(now, options) {
+					manager.notifySpeaking(c)
+				}
+				if speaking, level := c.activity.state(); speaking {
+					speakers = append(speakers, dto.ActiveSpeaker{
+						ConnectionId: c.Id,
+						UserId:       c.User.Id,
+						Level:        level,
+					})
+				}
+			}
+			if now.Sub(lastReport) < options.ReportInterval {
+				continue
+			}
+			lastReport = now
+			if len(speakers) > 0 || reported {
+				manager.broadcast(context, nil, dto.MessageActiveSpeakers, "", dto.ActiveSpeakersPayload{Speakers: speakers})
+			}
+			reported = len(speakers) > 0
+		}
+	}
+}
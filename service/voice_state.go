@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"voice-chat-server/dto"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+)
+
+func forbidden(format string, args ...interface{}) *SignalError {
+	return &SignalError{Code: dto.ErrorForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func (manager *ChatRoomConnectionManager) canModerate(conn *ChatRoomConn) bool {
+	return conn.HasPermission(models.PermissionModerate)
+}
+
+func (manager *ChatRoomConnectionManager) handleSelfVoiceState(conn *ChatRoomConn, msg *dto.Envelope) error {
+	manager.setVoiceState(conn, conn, func(state *dto.VoiceState) {
+		switch msg.Type {
+		case dto.MessageMute:
+			state.SelfMuted = true
+		case dto.MessageUnmute:
+			state.SelfMuted = false
+		case dto.MessageDeafen:
+			state.SelfDeafened = true
+		case dto.MessageUndeafen:
+			state.SelfDeafened = false
+		}
+	})
+	return nil
+}
+
+func (manager *ChatRoomConnectionManager) handleForceVoiceState(conn *ChatRoomConn, msg *dto.Envelope) error {
+	if !manager.canModerate(conn) {
+		return forbidden("only moderators can change the voice state of others")
+	}
+	var payload dto.ModerationPayload
+	if err := msg.DecodePayload(&payload); err != nil {
+		return badRequest("invalid moderation payload: %v", err)
+	}
+	target := conn.Context.Connection(payload.Target)
+	if target == nil {
+		return &SignalError{
+			Code:    dto.ErrorTargetNotFound,
+			Message: fmt.Sprintf("connection %s is not in room %d", payload.Target, conn.Context.RoomId),
+		}
+	}
+	if !manager.Permissions.CanActOn(conn.User, target.User.Id, conn.Context.ServerId, conn.Context.RoomId) {
+		return forbidden("can not change the voice state of users holding more permissions")
+	}
+	manager.setVoiceState(conn, target, func(state *dto.VoiceState) {
+		switch msg.Type {
+		case dto.MessageForceMute:
+			state.ForceMuted = true
+		case dto.MessageForceUnmute:
+			state.ForceMuted = false
+		case dto.MessageForceDeafen:
+			state.ForceDeafened = true
+		case dto.MessageForceUndeafen:
+			state.ForceDeafened = false
+		}
+	})
+	return nil
+}
+
+// setVoiceState applies the update to the target and tells the whole room who changed what.
+func (manager *ChatRoomConnectionManager) setVoiceState(actor *ChatRoomConn, target *ChatRoomConn, update func(state *dto.VoiceState)) {
+	previous, current := target.updateVoiceState(update)
+	if previous == current {
+		return
+	}
+	if manager.Sfu != nil && target.Context.MediaMode == MediaModeSfu {
+		if previous.Muted() != current.Muted() {
+			manager.Sfu.SetMuted(target.Context.RoomId, target.Id, !target.CanSpeak())
+		}
+		if previous.Deafened() != current.Deafened() {
+			manager.Sfu.SetDeafened(target.Context.RoomId, target.Id, current.Deafened())
+		}
+	}
+	logger.Logger.Debugf("Voice state of connection %s changed by %s: %+v", target.Id, actor.Id, current)
+
+	envelope, err := dto.NewEnvelope(dto.MessageVoiceState, "", target.Member())
+	if err != nil {
+		logger.Logger.Error(err)
+		return
+	}
+	envelope.From = actor.Sender()
+	manager.broadcastEnvelope(target.Context, nil, envelope)
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"sort"
+	"voice-chat-server/models"
+)
+
+func (store *MemoryStore) GetRole(id int64) (*models.ChatRole, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	role, ok := store.roles[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &role, nil
+}
+
+func (store *MemoryStore) ListRoles() ([]models.ChatRole, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	roles := make([]models.ChatRole, 0, len(store.roles))
+	for _, role := range store.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Id < roles[j].Id
+	})
+	return roles, nil
+}
+
+func (store *MemoryStore) CreateRole(role *models.ChatRole) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.roles[role.Id]; ok {
+		return ErrConflict
+	}
+	store.assignId("chat_role", &role.Id)
+	store.roles[role.Id] = *role
+	return nil
+}
+
+func (store *MemoryStore) GetServerRole(userId int64, serverId int64) (*models.ChatServerRole, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, serverRole := range store.serverRoles {
+		if serverRole.UserId == userId && serverRole.ServerId == serverId {
+			return &serverRole, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) SetServerRole(userId int64, serverId int64, roleId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, serverRole := range store.serverRoles {
+		if serverRole.UserId == userId && serverRole.ServerId == serverId {
+			serverRole.RoleId = roleId
+			store.serverRoles[key] = serverRole
+			return nil
+		}
+	}
+	serverRole := models.ChatServerRole{
+		UserId:   userId,
+		ServerId: serverId,
+		RoleId:   roleId,
+	}
+	store.assignId("chat_server_role", &serverRole.Id)
+	store.serverRoles[serverRole.Id] = serverRole
+	return nil
+}
+
+func (store *MemoryStore) GetRoomRole(userId int64, roomId int64) (*models.ChatRoomRole, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, roomRole := range store.roomRoles {
+		if roomRole.UserId == userId && roomRole.RoomId == roomId {
+			return &roomRole, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) SetRoomRole(userId int64, roomId int64, roleId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, roomRole := range store.roomRoles {
+		if roomRole.UserId == userId && roomRole.RoomId == roomId {
+			roomRole.RoleId = roleId
+			store.roomRoles[key] = roomRole
+			return nil
+		}
+	}
+	roomRole := models.ChatRoomRole{
+		UserId: userId,
+		RoomId: roomId,
+		RoleId: roleId,
+	}
+	store.assignId("chat_room_role", &roomRole.Id)
+	store.roomRoles[roomRole.Id] = roomRole
+	return nil
+}
+
+func (store *MemoryStore) DeleteRoomRole(userId int64, roomId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, roomRole := range store.roomRoles {
+		if roomRole.UserId == userId && roomRole.RoomId == roomId {
+			delete(store.roomRoles, key)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) ListRoomRolesOf(userId int64) ([]models.ChatRoomRole, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	roomRoles := make([]models.ChatRoomRole, 0)
+	for _, roomRole := range store.roomRoles {
+		if roomRole.UserId != userId {
+			continue
+		}
+		room, ok := store.rooms[roomRole.RoomId]
+		role, found := store.roles[roomRole.RoleId]
+		if !ok || !found {
+			continue
+		}
+		roomRole.Room, roomRole.Role = &room, &role
+		roomRoles = append(roomRoles, roomRole)
+	}
+	sort.Slice(roomRoles, func(i, j int) bool {
+		return roomRoles[i].Id < roomRoles[j].Id
+	})
+	return roomRoles, nil
+}
+
+func (store *MemoryStore) GetBan(id int64) (*models.ChatBan, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	ban, ok := store.bans[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &ban, nil
+}
+
+func (store *MemoryStore) ListBans(serverId int64, now int64) ([]models.ChatBan, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	bans := make([]models.ChatBan, 0)
+	for _, ban := range store.bans {
+		if ban.ServerId == serverId && (ban.Expires == 0 || ban.Expires > now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Id < bans[j].Id
+	})
+	return bans, nil
+}
+
+func (store *MemoryStore) ListBansOf(userId int64, serverId int64, roomId int64) ([]models.ChatBan, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	var bans []models.ChatBan
+	for _, ban := range store.bans {
+		if ban.UserId == userId && ban.ServerId == serverId && (ban.RoomId == 0 || ban.RoomId == roomId) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans, nil
+}
+
+func (store *MemoryStore) CreateBan(ban *models.ChatBan) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.assignId("chat_ban", &ban.Id)
+	copied := *ban
+	copied.User, copied.Server = nil, nil
+	store.bans[ban.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) DeleteBan(id int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.bans, id)
+	return nil
+}
+
+func (store *MemoryStore) GetInvite(id int64) (*models.ChatInvite, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	invite, ok := store.invites[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &invite, nil
+}
+
+func (store *MemoryStore) GetInviteByCode(code string) (*models.ChatInvite, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, invite := range store.invites {
+		if invite.Code == code {
+			return &invite, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) ListInvites(serverId int64) ([]models.ChatInvite, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	invites := make([]models.ChatInvite, 0)
+	for _, invite := range store.invites {
+		if invite.ServerId == serverId {
+			invites = append(invites, invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].Id < invites[j].Id
+	})
+	return invites, nil
+}
+
+func (store *MemoryStore) CreateInvite(invite *models.ChatInvite) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, existing := range store.invites {
+		if existing.Code == invite.Code {
+			return ErrConflict
+		}
+	}
+	store.assignId("chat_invite", &invite.Id)
+	copied := *invite
+	copied.Server = nil
+	store.invites[invite.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) RevokeInvite(id int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if invite, ok := store.invites[id]; ok {
+		invite.Revoked = true
+		store.invites[id] = invite
+	}
+	return nil
+}
+
+func (store *MemoryStore) UseInvite(invite *models.ChatInvite, now int64) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	stored, ok := store.invites[invite.Id]
+	if !ok || stored.Revoked || (stored.MaxUses != 0 && stored.Uses >= stored.MaxUses) ||
+		(stored.Expires != 0 && stored.Expires <= now) {
+		return false, nil
+	}
+	stored.Uses++
+	store.invites[invite.Id] = stored
+	invite.Uses = stored.Uses
+	return true, nil
+}
@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"sort"
+	"voice-chat-server/models"
+)
+
+func sortServers(servers []models.ChatServer) {
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Position != servers[j].Position {
+			return servers[i].Position < servers[j].Position
+		}
+		return servers[i].Id < servers[j].Id
+	})
+}
+
+func (store *MemoryStore) GetServer(id int64) (*models.ChatServer, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	server, ok := store.servers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &server, nil
+}
+
+func (store *MemoryStore) ListServers() ([]models.ChatServer, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	servers := make([]models.ChatServer, 0, len(store.servers))
+	for _, server := range store.servers {
+		servers = append(servers, server)
+	}
+	sortServers(servers)
+	return servers, nil
+}
+
+func (store *MemoryStore) ListServersOf(userId int64) ([]models.ChatServer, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	servers := make([]models.ChatServer, 0)
+	for _, member := range store.members {
+		if server, ok := store.servers[member.ServerId]; ok && member.UserId == userId {
+			servers = append(servers, server)
+		}
+	}
+	sortServers(servers)
+	return servers, nil
+}
+
+func (store *MemoryStore) CreateServer(server *models.ChatServer) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.servers[server.Id]; ok {
+		return ErrConflict
+	}
+	store.assignId("chat_server", &server.Id)
+	store.servers[server.Id] = *server
+	return nil
+}
+
+func (store *MemoryStore) UpdateServer(server *models.ChatServer) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.servers[server.Id]; !ok {
+		return ErrNotFound
+	}
+	store.servers[server.Id] = *server
+	return nil
+}
+
+func (store *MemoryStore) DeleteServer(id int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for roomId, room := range store.rooms {
+		if room.ServerId == id {
+			store.deleteRoomLocked(roomId)
+		}
+	}
+	for key, member := range store.members {
+		if member.ServerId == id {
+			delete(store.members, key)
+		}
+	}
+	for key, serverRole := range store.serverRoles {
+		if serverRole.ServerId == id {
+			delete(store.serverRoles, key)
+		}
+	}
+	for key, ban := range store.bans {
+		if ban.ServerId == id {
+			delete(store.bans, key)
+		}
+	}
+	for key, invite := range store.invites {
+		if invite.ServerId == id {
+			delete(store.invites, key)
+		}
+	}
+	delete(store.servers, id)
+	return nil
+}
+
+func (store *MemoryStore) findMemberLocked(serverId int64, userId int64) (int64, bool) {
+	for key, member := range store.members {
+		if member.ServerId == serverId && member.UserId == userId {
+			return key, true
+		}
+	}
+	return 0, false
+}
+
+func (store *MemoryStore) GetMember(serverId int64, userId int64) (*models.ChatServerMember, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	key, ok := store.findMemberLocked(serverId, userId)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	member := store.members[key]
+	return &member, nil
+}
+
+func (store *MemoryStore) ListMembers(serverId int64) ([]models.ChatServerMember, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	members := make([]models.ChatServerMember, 0)
+	for _, member := range store.members {
+		if member.ServerId != serverId {
+			continue
+		}
+		if user, ok := store.users[member.UserId]; ok {
+			member.User = &user
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Id < members[j].Id
+	})
+	return members, nil
+}
+
+func (store *MemoryStore) CreateMember(member *models.ChatServerMember) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.findMemberLocked(member.ServerId, member.UserId); ok {
+		return nil
+	}
+	store.assignId("chat_server_member", &member.Id)
+	copied := *member
+	copied.Server, copied.User = nil, nil
+	store.members[member.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) DeleteMember(serverId int64, userId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if key, ok := store.findMemberLocked(serverId, userId); ok {
+		delete(store.members, key)
+	}
+	for key, serverRole := range store.serverRoles {
+		if serverRole.ServerId == serverId && serverRole.UserId == userId {
+			delete(store.serverRoles, key)
+		}
+	}
+	for key, roomRole := range store.roomRoles {
+		if roomRole.UserId == userId && store.rooms[roomRole.RoomId].ServerId == serverId {
+			delete(store.roomRoles, key)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) SetNickname(serverId int64, userId int64, nickname string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	key, ok := store.findMemberLocked(serverId, userId)
+	if !ok {
+		return ErrNotFound
+	}
+	member := store.members[key]
+	member.Nickname = nickname
+	store.members[key] = member
+	return nil
+}
+
+func (store *MemoryStore) GetRoom(id int64) (*models.ChatRoom, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	room, ok := store.rooms[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &room, nil
+}
+
+func (store *MemoryStore) ListRooms(serverId int64) ([]models.ChatRoom, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	rooms := make([]models.ChatRoom, 0)
+	server, ok := store.servers[serverId]
+	if !ok {
+		return rooms, nil
+	}
+	for _, room := range store.rooms {
+		if room.ServerId == serverId {
+			room.Server = &server
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Position != rooms[j].Position {
+			return rooms[i].Position < rooms[j].Position
+		}
+		return rooms[i].Id < rooms[j].Id
+	})
+	return rooms, nil
+}
+
+func (store *MemoryStore) CreateRoom(room *models.ChatRoom) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.rooms[room.Id]; ok {
+		return ErrConflict
+	}
+	store.assignId("chat_room", &room.Id)
+	copied := *room
+	copied.Server = nil
+	store.rooms[room.Id] = copied
+	return nil
+}
+
+// UpdateRoom never moves the room to another server.
+func (store *MemoryStore) UpdateRoom(room *models.ChatRoom) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	existing, ok := store.rooms[room.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	copied := *room
+	copied.ServerId, copied.Server = existing.ServerId, nil
+	store.rooms[room.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) DeleteRoom(id int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.deleteRoomLocked(id)
+	return nil
+}
+
+func (store *MemoryStore) deleteRoomLocked(roomId int64) {
+	for key, stats := range store.connStats {
+		if stats.RoomId == roomId {
+			delete(store.connStats, key)
+		}
+	}
+	for key, ban := range store.bans {
+		if ban.RoomId == roomId {
+			delete(store.bans, key)
+		}
+	}
+	for key, invite := range store.invites {
+		if invite.RoomId == roomId {
+			delete(store.invites, key)
+		}
+	}
+	for key, access := range store.roomAccess {
+		if access.RoomId == roomId {
+			delete(store.roomAccess, key)
+		}
+	}
+	for key, roomRole := range store.roomRoles {
+		if roomRole.RoomId == roomId {
+			delete(store.roomRoles, key)
+		}
+	}
+	delete(store.rooms, roomId)
+}
+
+func (store *MemoryStore) HasRoomAccess(roomId int64, userId int64) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, access := range store.roomAccess {
+		if access.RoomId == roomId && access.UserId == userId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *MemoryStore) ListRoomAccess(roomId int64) ([]models.ChatRoomAccess, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	access := make([]models.ChatRoomAccess, 0)
+	for _, a := range store.roomAccess {
+		if a.RoomId == roomId {
+			access = append(access, a)
+		}
+	}
+	sort.Slice(access, func(i, j int) bool {
+		return access[i].Id < access[j].Id
+	})
+	return access, nil
+}
+
+func (store *MemoryStore) CreateRoomAccess(access *models.ChatRoomAccess) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, a := range store.roomAccess {
+		if a.RoomId == access.RoomId && a.UserId == access.UserId {
+			return nil
+		}
+	}
+	store.assignId("chat_room_access", &access.Id)
+	copied := *access
+	copied.Room, copied.User = nil, nil
+	store.roomAccess[access.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) DeleteRoomAccess(roomId int64, userId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, access := range store.roomAccess {
+		if access.RoomId == roomId && access.UserId == userId {
+			delete(store.roomAccess, key)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) ListConnStats(userId int64, roomId int64) ([]models.ChatUserConnStats, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	var stats []models.ChatUserConnStats
+	for _, s := range store.connStats {
+		if s.UserId == userId && s.RoomId == roomId {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+func (store *MemoryStore) CreateConnStats(stats *models.ChatUserConnStats) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.connStats[stats.Id]; ok {
+		return ErrConflict
+	}
+	copied := *stats
+	copied.User, copied.Room = nil, nil
+	store.connStats[stats.Id] = copied
+	return nil
+}
+
+func (store *MemoryStore) DeleteConnStats(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.connStats, id)
+	return nil
+}
+
+func (store *MemoryStore) DeleteUserConnStats(userId int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, stats := range store.connStats {
+		if stats.UserId == userId {
+			delete(store.connStats, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"voice-chat-server/models"
+)
+
+// MemoryStore keeps everything in memory, it starts empty and forgets everything on exit.
+// Records are copied in and out, relations are only set on what the lists return.
+type MemoryStore struct {
+	lock          sync.Mutex
+	sequences     map[string]int64
+	users         map[int64]models.ChatUser
+	sessions      map[string]models.UserSession
+	refreshTokens map[int64]models.RefreshToken
+	servers       map[int64]models.ChatServer
+	members       map[int64]models.ChatServerMember
+	rooms         map[int64]models.ChatRoom
+	roomAccess    map[int64]models.ChatRoomAccess
+	roles         map[int64]models.ChatRole
+	serverRoles   map[int64]models.ChatServerRole
+	roomRoles     map[int64]models.ChatRoomRole
+	bans          map[int64]models.ChatBan
+	invites       map[int64]models.ChatInvite
+	connStats     map[string]models.ChatUserConnStats
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sequences:     make(map[string]int64),
+		users:         make(map[int64]models.ChatUser),
+		sessions:      make(map[string]models.UserSession),
+		refreshTokens: make(map[int64]models.RefreshToken),
+		servers:       make(map[int64]models.ChatServer),
+		members:       make(map[int64]models.ChatServerMember),
+		rooms:         make(map[int64]models.ChatRoom),
+		roomAccess:    make(map[int64]models.ChatRoomAccess),
+		roles:         make(map[int64]models.ChatRole),
+		serverRoles:   make(map[int64]models.ChatServerRole),
+		roomRoles:     make(map[int64]models.ChatRoomRole),
+		bans:          make(map[int64]models.ChatBan),
+		invites:       make(map[int64]models.ChatInvite),
+		connStats:     make(map[string]models.ChatUserConnStats),
+	}
+}
+
+// MigrateUp has nothing to do, the memory store has no schema to version.
+func (store *MemoryStore) MigrateUp() ([]Migration, error) {
+	return make([]Migration, 0), nil
+}
+
+func (store *MemoryStore) MigrateDown() (*Migration, error) {
+	return nil, ErrNoMigration
+}
+
+func (store *MemoryStore) MigrationStatus() ([]MigrationStatus, error) {
+	return make([]MigrationStatus, 0), nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// assignId gives a new record the next id of its table, or records the id it already has.
+func (store *MemoryStore) assignId(table string, id *int64) {
+	if *id == 0 {
+		store.sequences[table]++
+		*id = store.sequences[table]
+	} else if *id > store.sequences[table] {
+		store.sequences[table] = *id
+	}
+}
+
+func (store *MemoryStore) GetUser(id int64) (*models.ChatUser, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	user, ok := store.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (store *MemoryStore) GetUserByUsername(username string) (*models.ChatUser, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, user := range store.users {
+		if user.UserName == username {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) ListUsers() ([]models.ChatUser, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.listUsersLocked(), nil
+}
+
+func (store *MemoryStore) listUsersLocked() []models.ChatUser {
+	users := make([]models.ChatUser, 0, len(store.users))
+	for _, user := range store.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Id < users[j].Id
+	})
+	return users
+}
+
+func (store *MemoryStore) CreateUser(user *models.ChatUser) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, existing := range store.users {
+		if existing.UserName == user.UserName {
+			return ErrConflict
+		}
+	}
+	if _, ok := store.users[user.Id]; ok {
+		return ErrConflict
+	}
+	store.assignId("chat_user", &user.Id)
+	store.users[user.Id] = *user
+	return nil
+}
+
+func (store *MemoryStore) UpdateUser(user *models.ChatUser) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.users[user.Id]; !ok {
+		return ErrNotFound
+	}
+	store.users[user.Id] = *user
+	return nil
+}
+
+// DeleteUser cascades like the foreign keys of the Postgres schema do.
+func (store *MemoryStore) DeleteUser(id int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.users, id)
+	for key, member := range store.members {
+		if member.UserId == id {
+			delete(store.members, key)
+		}
+	}
+	for key, access := range store.roomAccess {
+		if access.UserId == id {
+			delete(store.roomAccess, key)
+		}
+	}
+	for key, serverRole := range store.serverRoles {
+		if serverRole.UserId == id {
+			delete(store.serverRoles, key)
+		}
+	}
+	for key, roomRole := range store.roomRoles {
+		if roomRole.UserId == id {
+			delete(store.roomRoles, key)
+		}
+	}
+	for key, ban := range store.bans {
+		if ban.UserId == id {
+			delete(store.bans, key)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) GetSessionByToken(token string) (*models.UserSession, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, session := range store.sessions {
+		if session.Token == token {
+			return &session, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) GetSessionByUserName(username string) (*models.UserSession, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	session, ok := store.sessions[username]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &session, nil
+}
+
+func (store *MemoryStore) ListSessions() ([]models.UserSession, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	sessions := make([]models.UserSession, 0, len(store.sessions))
+	for _, session := range store.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UserName < sessions[j].UserName
+	})
+	return sessions, nil
+}
+
+func (store *MemoryStore) ReplaceSession(session *models.UserSession) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.sessions[session.UserName] = *session
+	return nil
+}
+
+func (store *MemoryStore) DeleteSessions(username string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.sessions, username)
+	return nil
+}
+
+func (store *MemoryStore) DeleteExpiredSessions(now int64) (int, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	deleted := 0
+	for key, session := range store.sessions {
+		if session.Expires < now {
+			delete(store.sessions, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (store *MemoryStore) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, token := range store.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (store *MemoryStore) CreateRefreshToken(token *models.RefreshToken) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, existing := range store.refreshTokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+	}
+	store.assignId("chat_refresh_token", &token.Id)
+	store.refreshTokens[token.Id] = *token
+	return nil
+}
+
+func (store *MemoryStore) UseRefreshToken(id int64) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	token, ok := store.refreshTokens[id]
+	if !ok || token.Used {
+		return false, nil
+	}
+	token.Used = true
+	store.refreshTokens[id] = token
+	return true, nil
+}
+
+func (store *MemoryStore) RevokeRefreshTokens(username string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, token := range store.refreshTokens {
+		if token.UserName == username {
+			token.Revoked = true
+			store.refreshTokens[key] = token
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) RevokeTokenFamily(family string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, token := range store.refreshTokens {
+		if token.Family == family {
+			token.Revoked = true
+			store.refreshTokens[key] = token
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) DeleteExpiredRefreshTokens(now int64) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, token := range store.refreshTokens {
+		if token.Expires < now {
+			delete(store.refreshTokens, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoMigration = errors.New("no migration to revert")
+
+// Migration is one numbered change of the schema. Statements of a migration run in a single
+// transaction, released migrations must never change, add a new one instead.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is 0 while the migration is pending.
+	AppliedAt int64
+}
+
+// Migrator versions the schema of a store, the applied versions are kept in the
+// schema_migrations table. Only one instance migrates a database at a time.
+type Migrator interface {
+	// MigrateUp applies the pending migrations in order and returns them.
+	MigrateUp() ([]Migration, error)
+	// MigrateDown reverts the last applied migration, ErrNoMigration when there is none.
+	MigrateDown() (*Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// migrationSession is a connection of a store holding the migration lock.
+type migrationSession interface {
+	// appliedMigrations maps the applied versions to when they were applied.
+	appliedMigrations() (map[int]int64, error)
+	// applyMigration runs the up or down statements and records the version accordingly,
+	// all in one transaction.
+	applyMigration(migration Migration, up bool, now int64) error
+}
+
+// migrationLock runs fn while no other instance can migrate the same database.
+type migrationLock func(fn func(session migrationSession) error) error
+
+func migrateUp(lock migrationLock, migrations []Migration) ([]Migration, error) {
+	done := make([]Migration, 0)
+	err := lock(func(session migrationSession) error {
+		applied, err := session.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			now := time.Now().UnixNano() / int64(time.Millisecond)
+			if err = session.applyMigration(migration, true, now); err != nil {
+				return fmt.Errorf("can not apply migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+func migrateDown(lock migrationLock, migrations []Migration) (*Migration, error) {
+	var reverted *Migration
+	err := lock(func(session migrationSession) error {
+		applied, err := session.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err = session.applyMigration(migration, false, 0); err != nil {
+				return fmt.Errorf("can not revert migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			reverted = &migration
+			return nil
+		}
+		return ErrNoMigration
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reverted, nil
+}
+
+func migrationStatus(lock migrationLock, migrations []Migration) ([]MigrationStatus, error) {
+	status := make([]MigrationStatus, 0, len(migrations))
+	err := lock(func(session migrationSession) error {
+		applied, err := session.appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status = append(status, MigrationStatus{
+				Migration: migration,
+				AppliedAt: applied[migration.Version],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"github.com/go-pg/pg/v9"
+)
+
+// migrationLockKey identifies the advisory lock held while migrating, every instance has to
+// use the same one.
+const migrationLockKey = 4720193318
+
+// postgresMigrations start from the tables the first releases created with go-pg, the
+// IF NOT EXISTS let databases created that way adopt them.
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS chat_user_session (
+				"user_name" varchar(255) NOT NULL UNIQUE,
+				"token" varchar(255) NOT NULL UNIQUE,
+				"create_at" bigint NOT NULL,
+				"expires" bigint NOT NULL,
+				UNIQUE ("user_name", "token"))`,
+			`CREATE TABLE IF NOT EXISTS chat_user (
+				"id" bigint NOT NULL UNIQUE,
+				"name" varchar(255) NOT NULL,
+				"user_name" varchar(255) NOT NULL UNIQUE,
+				"password" varchar(255) NOT NULL,
+				PRIMARY KEY ("id"),
+				UNIQUE ("id", "user_name"))`,
+			`CREATE TABLE IF NOT EXISTS chat_server (
+				"id" bigint NOT NULL UNIQUE,
+				"name" varchar(255) NOT NULL,
+				"description" varchar(255) NOT NULL,
+				PRIMARY KEY ("id"),
+				UNIQUE ("id"))`,
+			`CREATE TABLE IF NOT EXISTS chat_room (
+				"id" bigint NOT NULL UNIQUE,
+				"name" varchar(255) NOT NULL,
+				"description" varchar(255) NOT NULL,
+				"server_id" bigint,
+				PRIMARY KEY ("id"),
+				UNIQUE ("id"),
+				FOREIGN KEY ("server_id") REFERENCES chat_server ("id") ON DELETE RESTRICT ON UPDATE CASCADE)`,
+			`CREATE TABLE IF NOT EXISTS chat_user_conn_stats (
+				"id" varchar(255) NOT NULL UNIQUE,
+				"user_id" bigint,
+				"room_id" bigint,
+				PRIMARY KEY ("id"),
+				UNIQUE ("id"),
+				FOREIGN KEY ("room_id") REFERENCES chat_room ("id") ON DELETE RESTRICT ON UPDATE CASCADE,
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE RESTRICT ON UPDATE CASCADE)`,
+		},
+		Down: []string{
+			`DROP TABLE chat_user_conn_stats`,
+			`DROP TABLE chat_room`,
+			`DROP TABLE chat_server`,
+			`DROP TABLE chat_user`,
+			`DROP TABLE chat_user_session`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "user, server and room settings",
+		Up: []string{
+			`ALTER TABLE chat_user ADD COLUMN IF NOT EXISTS "disabled" boolean`,
+			`ALTER TABLE chat_server
+				ADD COLUMN IF NOT EXISTS "position" bigint,
+				ADD COLUMN IF NOT EXISTS "public" boolean`,
+			`ALTER TABLE chat_room
+				ADD COLUMN IF NOT EXISTS "media_mode" varchar(16),
+				ADD COLUMN IF NOT EXISTS "mixing" boolean,
+				ADD COLUMN IF NOT EXISTS "position" bigint,
+				ADD COLUMN IF NOT EXISTS "capacity" bigint,
+				ADD COLUMN IF NOT EXISTS "max_speakers" bigint,
+				ADD COLUMN IF NOT EXISTS "waiting_queue" boolean,
+				ADD COLUMN IF NOT EXISTS "password" varchar(255),
+				ADD COLUMN IF NOT EXISTS "private" boolean`,
+		},
+		Down: []string{
+			`ALTER TABLE chat_room
+				DROP COLUMN "media_mode",
+				DROP COLUMN "mixing",
+				DROP COLUMN "position",
+				DROP COLUMN "capacity",
+				DROP COLUMN "max_speakers",
+				DROP COLUMN "waiting_queue",
+				DROP COLUMN "password",
+				DROP COLUMN "private"`,
+			`ALTER TABLE chat_server DROP COLUMN "position", DROP COLUMN "public"`,
+			`ALTER TABLE chat_user DROP COLUMN "disabled"`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "roles, bans and room access",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS chat_role (
+				"id" bigint NOT NULL UNIQUE,
+				"name" varchar(64) NOT NULL UNIQUE,
+				"permissions" bigint NOT NULL,
+				PRIMARY KEY ("id"),
+				UNIQUE ("id", "name"))`,
+			`CREATE TABLE IF NOT EXISTS chat_server_role (
+				"id" bigserial,
+				"user_id" bigint,
+				"server_id" bigint,
+				"role_id" bigint,
+				PRIMARY KEY ("id"),
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("server_id") REFERENCES chat_server ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("role_id") REFERENCES chat_role ("id") ON DELETE RESTRICT ON UPDATE CASCADE)`,
+			`CREATE TABLE IF NOT EXISTS chat_room_role (
+				"id" bigserial,
+				"user_id" bigint,
+				"room_id" bigint,
+				"role_id" bigint,
+				PRIMARY KEY ("id"),
+				FOREIGN KEY ("role_id") REFERENCES chat_role ("id") ON DELETE RESTRICT ON UPDATE CASCADE,
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("room_id") REFERENCES chat_room ("id") ON DELETE CASCADE ON UPDATE CASCADE)`,
+			`CREATE TABLE IF NOT EXISTS chat_ban (
+				"id" bigserial,
+				"user_id" bigint,
+				"server_id" bigint,
+				"room_id" bigint,
+				"reason" varchar(255),
+				"created_by" bigint,
+				"create_at" bigint NOT NULL,
+				"expires" bigint,
+				PRIMARY KEY ("id"),
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("server_id") REFERENCES chat_server ("id") ON DELETE CASCADE ON UPDATE CASCADE)`,
+			`CREATE TABLE IF NOT EXISTS chat_room_access (
+				"id" bigserial,
+				"room_id" bigint,
+				"user_id" bigint,
+				"created_by" bigint,
+				"create_at" bigint NOT NULL,
+				PRIMARY KEY ("id"),
+				FOREIGN KEY ("room_id") REFERENCES chat_room ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE CASCADE ON UPDATE CASCADE)`,
+		},
+		Down: []string{
+			`DROP TABLE chat_room_access`,
+			`DROP TABLE chat_ban`,
+			`DROP TABLE chat_room_role`,
+			`DROP TABLE chat_server_role`,
+			`DROP TABLE chat_role`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "invites and server members",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS chat_invite (
+				"id" bigserial,
+				"code" varchar(32) NOT NULL UNIQUE,
+				"server_id" bigint,
+				"room_id" bigint,
+				"role_id" bigint,
+				"max_uses" bigint,
+				"uses" bigint,
+				"created_by" bigint,
+				"create_at" bigint NOT NULL,
+				"expires" bigint,
+				"revoked" boolean,
+				PRIMARY KEY ("id"),
+				UNIQUE ("code"),
+				FOREIGN KEY ("server_id") REFERENCES chat_server ("id") ON DELETE CASCADE ON UPDATE CASCADE)`,
+			`CREATE TABLE IF NOT EXISTS chat_server_member (
+				"id" bigserial,
+				"server_id" bigint,
+				"user_id" bigint,
+				"nickname" varchar(64),
+				"joined_at" bigint NOT NULL,
+				PRIMARY KEY ("id"),
+				FOREIGN KEY ("server_id") REFERENCES chat_server ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+				FOREIGN KEY ("user_id") REFERENCES chat_user ("id") ON DELETE CASCADE ON UPDATE CASCADE)`,
+		},
+		Down: []string{
+			`DROP TABLE chat_server_member`,
+			`DROP TABLE chat_invite`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "refresh tokens",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS chat_refresh_token (
+				"id" bigserial,
+				"user_name" varchar(255) NOT NULL,
+				"family" varchar(64) NOT NULL,
+				"token_hash" varchar(64) NOT NULL UNIQUE,
+				"create_at" bigint NOT NULL,
+				"expires" bigint NOT NULL,
+				"used" boolean,
+				"revoked" boolean,
+				PRIMARY KEY ("id"),
+				UNIQUE ("token_hash"))`,
+		},
+		Down: []string{
+			`DROP TABLE chat_refresh_token`,
+		},
+	},
+	{
+		// servers used to be open to everyone, databases from before memberships make their
+		// users members of every server, the others already have members
+		Version: 6,
+		Name:    "server members of existing users",
+		Up: []string{
+			`INSERT INTO chat_server_member (server_id, user_id, joined_at)
+				SELECT s.id, u.id, (extract(epoch FROM now()) * 1000)::bigint
+				FROM chat_server s CROSS JOIN chat_user u
+				WHERE NOT EXISTS (SELECT 1 FROM chat_server_member)`,
+		},
+	},
+	{
+		// ids of users, servers and rooms used to be picked with MAX(id) + 1, which hands the
+		// same id to concurrent inserts
+		Version: 7,
+		Name:    "id sequences",
+		Up: []string{
+			`CREATE SEQUENCE chat_user_id_seq OWNED BY chat_user.id`,
+			`SELECT setval('chat_user_id_seq', COALESCE((SELECT MAX(id) FROM chat_user), 0) + 1, false)`,
+			`ALTER TABLE chat_user ALTER COLUMN "id" SET DEFAULT nextval('chat_user_id_seq')`,
+			`CREATE SEQUENCE chat_server_id_seq OWNED BY chat_server.id`,
+			`SELECT setval('chat_server_id_seq', COALESCE((SELECT MAX(id) FROM chat_server), 0) + 1, false)`,
+			`ALTER TABLE chat_server ALTER COLUMN "id" SET DEFAULT nextval('chat_server_id_seq')`,
+			`CREATE SEQUENCE chat_room_id_seq OWNED BY chat_room.id`,
+			`SELECT setval('chat_room_id_seq', COALESCE((SELECT MAX(id) FROM chat_room), 0) + 1, false)`,
+			`ALTER TABLE chat_room ALTER COLUMN "id" SET DEFAULT nextval('chat_room_id_seq')`,
+		},
+		Down: []string{
+			`ALTER TABLE chat_room ALTER COLUMN "id" DROP DEFAULT`,
+			`DROP SEQUENCE chat_room_id_seq`,
+			`ALTER TABLE chat_server ALTER COLUMN "id" DROP DEFAULT`,
+			`DROP SEQUENCE chat_server_id_seq`,
+			`ALTER TABLE chat_user ALTER COLUMN "id" DROP DEFAULT`,
+			`DROP SEQUENCE chat_user_id_seq`,
+		},
+	},
+	{
+		// duplicate members, roles and room access used to be possible when two requests
+		// raced, the oldest row of each user is kept
+		Version: 8,
+		Name:    "unique members, roles and room access",
+		Up: []string{
+			`DELETE FROM chat_server_member d USING chat_server_member k
+				WHERE d.server_id = k.server_id AND d.user_id = k.user_id AND d.id > k.id`,
+			`ALTER TABLE chat_server_member ADD CONSTRAINT chat_server_member_server_id_user_id_key UNIQUE ("server_id", "user_id")`,
+			`DELETE FROM chat_server_role d USING chat_server_role k
+				WHERE d.server_id = k.server_id AND d.user_id = k.user_id AND d.id > k.id`,
+			`ALTER TABLE chat_server_role ADD CONSTRAINT chat_server_role_server_id_user_id_key UNIQUE ("server_id", "user_id")`,
+			`DELETE FROM chat_room_role d USING chat_room_role k
+				WHERE d.room_id = k.room_id AND d.user_id = k.user_id AND d.id > k.id`,
+			`ALTER TABLE chat_room_role ADD CONSTRAINT chat_room_role_room_id_user_id_key UNIQUE ("room_id", "user_id")`,
+			`DELETE FROM chat_room_access d USING chat_room_access k
+				WHERE d.room_id = k.room_id AND d.user_id = k.user_id AND d.id > k.id`,
+			`ALTER TABLE chat_room_access ADD CONSTRAINT chat_room_access_room_id_user_id_key UNIQUE ("room_id", "user_id")`,
+		},
+		Down: []string{
+			`ALTER TABLE chat_room_access DROP CONSTRAINT chat_room_access_room_id_user_id_key`,
+			`ALTER TABLE chat_room_role DROP CONSTRAINT chat_room_role_room_id_user_id_key`,
+			`ALTER TABLE chat_server_role DROP CONSTRAINT chat_server_role_server_id_user_id_key`,
+			`ALTER TABLE chat_server_member DROP CONSTRAINT chat_server_member_server_id_user_id_key`,
+		},
+	},
+}
+
+// postgresMigrationSession migrates through a single connection, the one holding the
+// advisory lock.
+type postgresMigrationSession struct {
+	conn *pg.Conn
+}
+
+func (store *PostgresStore) withMigrationLock(fn func(session migrationSession) error) error {
+	conn := store.DB.Conn()
+	defer conn.Close()
+	if _, err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+	}()
+	_, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		"version" bigint NOT NULL,
+		"name" varchar(255) NOT NULL,
+		"applied_at" bigint NOT NULL,
+		PRIMARY KEY ("version"))`)
+	if err != nil {
+		return err
+	}
+	return fn(postgresMigrationSession{conn: conn})
+}
+
+func (session postgresMigrationSession) appliedMigrations() (map[int]int64, error) {
+	var rows []struct {
+		Version   int
+		AppliedAt int64
+	}
+	_, err := session.conn.Query(&rows, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]int64, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+func (session postgresMigrationSession) applyMigration(migration Migration, up bool, now int64) error {
+	return session.conn.RunInTransaction(func(tx *pg.Tx) error {
+		statements := migration.Down
+		if up {
+			statements = migration.Up
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		var err error
+		if up {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, now)
+		} else {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		}
+		return err
+	})
+}
+
+func (store *PostgresStore) MigrateUp() ([]Migration, error) {
+	return migrateUp(store.withMigrationLock, postgresMigrations)
+}
+
+func (store *PostgresStore) MigrateDown() (*Migration, error) {
+	return migrateDown(store.withMigrationLock, postgresMigrations)
+}
+
+func (store *PostgresStore) MigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(store.withMigrationLock, postgresMigrations)
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"github.com/go-pg/pg/v9/orm"
+	"voice-chat-server/models"
+)
+
+func (store *PostgresStore) GetRole(id int64) (*models.ChatRole, error) {
+	var role models.ChatRole
+	err := store.DB.Model(&role).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &role, nil
+}
+
+func (store *PostgresStore) ListRoles() ([]models.ChatRole, error) {
+	roles := make([]models.ChatRole, 0)
+	err := store.DB.Model(&roles).Order("id").Select()
+	return roles, err
+}
+
+func (store *PostgresStore) CreateRole(role *models.ChatRole) error {
+	return store.DB.Insert(role)
+}
+
+func (store *PostgresStore) GetServerRole(userId int64, serverId int64) (*models.ChatServerRole, error) {
+	var serverRole models.ChatServerRole
+	err := store.DB.Model(&serverRole).
+		Where("user_id = ?", userId).
+		Where("server_id = ?", serverId).
+		First()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &serverRole, nil
+}
+
+func (store *PostgresStore) SetServerRole(userId int64, serverId int64, roleId int64) error {
+	serverRole := &models.ChatServerRole{
+		UserId:   userId,
+		ServerId: serverId,
+		RoleId:   roleId,
+	}
+	_, err := store.DB.Model(serverRole).
+		OnConflict("(server_id, user_id) DO UPDATE").
+		Set("role_id = EXCLUDED.role_id").
+		Insert()
+	return err
+}
+
+func (store *PostgresStore) GetRoomRole(userId int64, roomId int64) (*models.ChatRoomRole, error) {
+	var roomRole models.ChatRoomRole
+	err := store.DB.Model(&roomRole).
+		Where("user_id = ?", userId).
+		Where("room_id = ?", roomId).
+		First()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &roomRole, nil
+}
+
+func (store *PostgresStore) SetRoomRole(userId int64, roomId int64, roleId int64) error {
+	roomRole := &models.ChatRoomRole{
+		UserId: userId,
+		RoomId: roomId,
+		RoleId: roleId,
+	}
+	_, err := store.DB.Model(roomRole).
+		OnConflict("(room_id, user_id) DO UPDATE").
+		Set("role_id = EXCLUDED.role_id").
+		Insert()
+	return err
+}
+
+func (store *PostgresStore) DeleteRoomRole(userId int64, roomId int64) error {
+	_, err := store.DB.Model((*models.ChatRoomRole)(nil)).
+		Where("user_id = ?", userId).
+		Where("room_id = ?", roomId).
+		Delete()
+	return err
+}
+
+func (store *PostgresStore) ListRoomRolesOf(userId int64) ([]models.ChatRoomRole, error) {
+	roomRoles := make([]models.ChatRoomRole, 0)
+	err := store.DB.Model(&roomRoles).
+		Relation("Room").
+		Relation("Role").
+		Where("chat_room_role.user_id = ?", userId).
+		Select()
+	return roomRoles, err
+}
+
+func (store *PostgresStore) GetBan(id int64) (*models.ChatBan, error) {
+	var ban models.ChatBan
+	err := store.DB.Model(&ban).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &ban, nil
+}
+
+func (store *PostgresStore) ListBans(serverId int64, now int64) ([]models.ChatBan, error) {
+	bans := make([]models.ChatBan, 0)
+	err := store.DB.Model(&bans).
+		Where("server_id = ?", serverId).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("expires = 0").WhereOr("expires > ?", now), nil
+		}).
+		Order("id").
+		Select()
+	return bans, err
+}
+
+func (store *PostgresStore) ListBansOf(userId int64, serverId int64, roomId int64) ([]models.ChatBan, error) {
+	var bans []models.ChatBan
+	err := store.DB.Model(&bans).
+		Where("user_id = ?", userId).
+		Where("server_id = ?", serverId).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("room_id = 0").WhereOr("room_id = ?", roomId), nil
+		}).
+		Select()
+	return bans, err
+}
+
+func (store *PostgresStore) CreateBan(ban *models.ChatBan) error {
+	return store.DB.Insert(ban)
+}
+
+func (store *PostgresStore) DeleteBan(id int64) error {
+	_, err := store.DB.Model((*models.ChatBan)(nil)).
+		Where("id = ?", id).
+		Delete()
+	return err
+}
+
+func (store *PostgresStore) GetInvite(id int64) (*models.ChatInvite, error) {
+	var invite models.ChatInvite
+	err := store.DB.Model(&invite).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &invite, nil
+}
+
+func (store *PostgresStore) GetInviteByCode(code string) (*models.ChatInvite, error) {
+	var invite models.ChatInvite
+	err := store.DB.Model(&invite).Where("code = ?", code).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &invite, nil
+}
+
+func (store *PostgresStore) ListInvites(serverId int64) ([]models.ChatInvite, error) {
+	invites := make([]models.ChatInvite, 0)
+	err := store.DB.Model(&invites).
+		Where("server_id = ?", serverId).
+		Order("id").
+		Select()
+	return invites, err
+}
+
+func (store *PostgresStore) CreateInvite(invite *models.ChatInvite) error {
+	return store.DB.Insert(invite)
+}
+
+func (store *PostgresStore) RevokeInvite(id int64) error {
+	_, err := store.DB.Model((*models.ChatInvite)(nil)).
+		Set("revoked = ?", true).
+		Where("id = ?", id).
+		Update()
+	return err
+}
+
+func (store *PostgresStore) UseInvite(invite *models.ChatInvite, now int64) (bool, error) {
+	result, err := store.DB.Model(invite).
+		Set("uses = uses + 1").
+		Where("id = ?id").
+		Where("revoked = false").
+		Where("max_uses = 0 OR uses < max_uses").
+		Where("expires = 0 OR expires > ?", now).
+		Returning("uses").
+		Update()
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
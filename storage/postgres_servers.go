@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"github.com/go-pg/pg/v9"
+	"voice-chat-server/models"
+)
+
+func (store *PostgresStore) GetServer(id int64) (*models.ChatServer, error) {
+	var server models.ChatServer
+	err := store.DB.Model(&server).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &server, nil
+}
+
+func (store *PostgresStore) ListServers() ([]models.ChatServer, error) {
+	servers := make([]models.ChatServer, 0)
+	err := store.DB.Model(&servers).Order("position", "id").Select()
+	return servers, err
+}
+
+func (store *PostgresStore) ListServersOf(userId int64) ([]models.ChatServer, error) {
+	servers := make([]models.ChatServer, 0)
+	err := store.DB.Model(&servers).
+		Join("join chat_server_member as member").
+		JoinOn("member.server_id = chat_server.id").
+		JoinOn("member.user_id = ?", userId).
+		Order("position", "id").
+		Select()
+	return servers, err
+}
+
+func (store *PostgresStore) CreateServer(server *models.ChatServer) error {
+	return store.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return insertWithId(tx, "chat_server", server, server.Id)
+	})
+}
+
+func (store *PostgresStore) UpdateServer(server *models.ChatServer) error {
+	_, err := store.DB.Model(server).
+		Column("name", "description", "position", "public").
+		WherePK().
+		Update()
+	return err
+}
+
+func (store *PostgresStore) DeleteServer(id int64) error {
+	return store.DB.RunInTransaction(func(tx *pg.Tx) error {
+		var rooms []models.ChatRoom
+		err := tx.Model(&rooms).Where("server_id = ?", id).Select()
+		if err != nil {
+			return err
+		}
+		for _, room := range rooms {
+			if err = deleteRoom(tx, room.Id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Model((*models.ChatServer)(nil)).Where("id = ?", id).Delete()
+		return err
+	})
+}
+
+func (store *PostgresStore) GetMember(serverId int64, userId int64) (*models.ChatServerMember, error) {
+	var member models.ChatServerMember
+	err := store.DB.Model(&member).
+		Where("server_id = ?", serverId).
+		Where("user_id = ?", userId).
+		First()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &member, nil
+}
+
+func (store *PostgresStore) ListMembers(serverId int64) ([]models.ChatServerMember, error) {
+	members := make([]models.ChatServerMember, 0)
+	err := store.DB.Model(&members).
+		Relation("User").
+		Where("chat_server_member.server_id = ?", serverId).
+		Order("chat_server_member.id").
+		Select()
+	return members, err
+}
+
+func (store *PostgresStore) CreateMember(member *models.ChatServerMember) error {
+	_, err := store.DB.Model(member).OnConflict("(server_id, user_id) DO NOTHING").Insert()
+	return err
+}
+
+func (store *PostgresStore) DeleteMember(serverId int64, userId int64) error {
+	return store.DB.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Model((*models.ChatServerMember)(nil)).
+			Where("server_id = ?", serverId).
+			Where("user_id = ?", userId).
+			Delete()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Model((*models.ChatServerRole)(nil)).
+			Where("server_id = ?", serverId).
+			Where("user_id = ?", userId).
+			Delete()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM chat_room_role WHERE user_id = ?
+			AND room_id IN (SELECT id FROM chat_room WHERE server_id = ?)`, userId, serverId)
+		return err
+	})
+}
+
+func (store *PostgresStore) SetNickname(serverId int64, userId int64, nickname string) error {
+	result, err := store.DB.Model((*models.ChatServerMember)(nil)).
+		Set("nickname = ?", nickname).
+		Where("server_id = ?", serverId).
+		Where("user_id = ?", userId).
+		Update()
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (store *PostgresStore) GetRoom(id int64) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := store.DB.Model(&room).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, pgError(err)
+	}
+	return &room, nil
+}
+
+func (store *PostgresStore) ListRooms(serverId int64) ([]models.ChatRoom, error) {
+	rooms := make([]models.ChatRoom, 0)
+	err := store.DB.Model(&rooms).
+		Column("chat_room.*").
+		Relation("Server").
+		Join("join chat_server as svr").
+		JoinOn("svr.id = chat_room.server_id").
+		JoinOn("svr.id = ?", serverId).
+		Order("chat_room.position", "chat_room.id").
+		Select()
+	return rooms, err
+}
+
+func (store *PostgresStore) CreateRoom(room *models.ChatRoom) error {
+	return store.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return insertWithId(tx, "chat_room", room, room.Id)
+	})
+}
+
+func (store *PostgresStore) UpdateRoom(room *models.ChatRoom) error {
+	_, err := store.DB.Model(room).
+		Column("name", "description", "media_mode", "mixing", "position", "capacity",
+			"max_speakers", "waiting_queue", "password", "private").
+		WherePK().
+		Update()
+	return err
+}
+
+func (store *PostgresStore) DeleteRoom(id int64) error {
+	return store.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return deleteRoom(tx, id)
+	})
+}
+
+// deleteRoom removes what refers to the room without cascading, access lists and roles of
+// the room go away through their foreign keys.
+func deleteRoom(tx *pg.Tx, roomId int64) error {
+	_, err := tx.Model((*models.ChatUserConnStats)(nil)).Where("room_id = ?", roomId).Delete()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Model((*models.ChatBan)(nil)).Where("room_id = ?", roomId).Delete()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Model((*models.ChatInvite)(nil)).Where("room_id = ?", roomId).Delete()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Model((*models.ChatRoom)(nil)).Where("id = ?", roomId).Delete()
+	return err
+}
+
+func (store *PostgresStore) HasRoomAccess(roomId int64, userId int64) (bool, error) {
+	count, err := store.DB.Model((*models.ChatRoomAccess)(nil)).
+		Where("room_id = ?", roomId).
+		Where("user_id = ?", userId).
+		Count()
+	return count > 0, err
+}
+
+func (store *PostgresStore) ListRoomAccess(roomId int64) ([]models.ChatRoomAccess, error) {
+	access := make([]models.ChatRoomAccess, 0)
+	err := store.DB.Model(&access).Where("room_id = ?", roomId).Order("id").Select()
+	return access, err
+}
+
+func (store *PostgresStore) CreateRoomAccess(access *models.ChatRoomAccess) error {
+	_, err := store.DB.Model(access).OnConflict("(room_id, user_id) DO NOTHING").Insert()
+	return err
+}
+
+func (store *PostgresStore) DeleteRoomAccess(roomId int64, userId int64) error {
+	_, err := store.DB.Model((*models.ChatRoomAccess)(nil)).
+		Where("room_id = ?", roomId).
+		Where("user_id = ?", userId).
+		Delete()
+	return err
+}
+
+func (store *PostgresStore) ListConnStats(userId int64, roomId int64) ([]models.ChatUserConnStats, error) {
+	var stats []models.ChatUserConnStats
+	err := store.DB.Model(&stats).
+		Where("user_id = ?", userId).
+		Where("room_id = ?", roomId).
+		Select()
+	return stats, err
+}
+
+func (store *PostgresStore) CreateConnStats(stats *models.ChatUserConnStats) error {
+	return store.DB.Insert(stats)
+}
+
+func (store *PostgresStore) DeleteConnStats(id string) error {
+	_, err := store.DB.Model((*models.ChatUserConnStats)(nil)).
+		Where("id = ?", id).
+		Delete()
+	return err
+}
+
+func (store *PostgresStore) DeleteUserConnStats(userId int64) error {
+	_, err := store.DB.Model((*models.ChatUserConnStats)(nil)).
+		Where("user_id = ?", userId).
+		Delete()
+	return err
+}
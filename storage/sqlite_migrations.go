@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+// sqliteMigrations start from the tables the first release of the SQLite store created, the
+// IF NOT EXISTS let those databases adopt them.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS chat_user_session (
+				user_name TEXT NOT NULL UNIQUE,
+				token TEXT NOT NULL UNIQUE,
+				create_at INTEGER NOT NULL,
+				expires INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_refresh_token (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_name TEXT NOT NULL,
+				family TEXT NOT NULL,
+				token_hash TEXT NOT NULL UNIQUE,
+				create_at INTEGER NOT NULL,
+				expires INTEGER NOT NULL,
+				used INTEGER NOT NULL DEFAULT 0,
+				revoked INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_user (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				user_name TEXT NOT NULL UNIQUE,
+				password TEXT NOT NULL,
+				disabled INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_server (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				description TEXT NOT NULL,
+				position INTEGER NOT NULL DEFAULT 0,
+				public INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_room (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				description TEXT NOT NULL,
+				media_mode TEXT NOT NULL DEFAULT '',
+				mixing INTEGER NOT NULL DEFAULT 0,
+				position INTEGER NOT NULL DEFAULT 0,
+				capacity INTEGER NOT NULL DEFAULT 0,
+				max_speakers INTEGER NOT NULL DEFAULT 0,
+				waiting_queue INTEGER NOT NULL DEFAULT 0,
+				password TEXT NOT NULL DEFAULT '',
+				private INTEGER NOT NULL DEFAULT 0,
+				server_id INTEGER NOT NULL REFERENCES chat_server (id) ON DELETE RESTRICT ON UPDATE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_room_access (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				room_id INTEGER NOT NULL REFERENCES chat_room (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				created_by INTEGER NOT NULL DEFAULT 0,
+				create_at INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_server_member (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				server_id INTEGER NOT NULL REFERENCES chat_server (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				nickname TEXT NOT NULL DEFAULT '',
+				joined_at INTEGER NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_role (
+				id INTEGER PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				permissions INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_server_role (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				server_id INTEGER NOT NULL REFERENCES chat_server (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				role_id INTEGER NOT NULL REFERENCES chat_role (id) ON DELETE RESTRICT ON UPDATE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_room_role (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				room_id INTEGER NOT NULL REFERENCES chat_room (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				role_id INTEGER NOT NULL REFERENCES chat_role (id) ON DELETE RESTRICT ON UPDATE CASCADE
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_ban (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				server_id INTEGER NOT NULL REFERENCES chat_server (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				room_id INTEGER NOT NULL DEFAULT 0,
+				reason TEXT NOT NULL DEFAULT '',
+				created_by INTEGER NOT NULL DEFAULT 0,
+				create_at INTEGER NOT NULL,
+				expires INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_invite (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				code TEXT NOT NULL UNIQUE,
+				server_id INTEGER NOT NULL REFERENCES chat_server (id) ON DELETE CASCADE ON UPDATE CASCADE,
+				room_id INTEGER NOT NULL DEFAULT 0,
+				role_id INTEGER NOT NULL DEFAULT 0,
+				max_uses INTEGER NOT NULL DEFAULT 0,
+				uses INTEGER NOT NULL DEFAULT 0,
+				created_by INTEGER NOT NULL DEFAULT 0,
+				create_at INTEGER NOT NULL,
+				expires INTEGER NOT NULL DEFAULT 0,
+				revoked INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE TABLE IF NOT EXISTS chat_user_conn_stats (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL REFERENCES chat_user (id) ON DELETE RESTRICT ON UPDATE CASCADE,
+				room_id INTEGER NOT NULL REFERENCES chat_room (id) ON DELETE RESTRICT ON UPDATE CASCADE
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE chat_user_conn_stats`,
+			`DROP TABLE chat_invite`,
+			`DROP TABLE chat_ban`,
+			`DROP TABLE chat_room_role`,
+			`DROP TABLE chat_server_role`,
+			`DROP TABLE chat_role`,
+			`DROP TABLE chat_server_member`,
+			`DROP TABLE chat_room_access`,
+			`DROP TABLE chat_room`,
+			`DROP TABLE chat_server`,
+			`DROP TABLE chat_user`,
+			`DROP TABLE chat_refresh_token`,
+			`DROP TABLE chat_user_session`,
+		},
+	},
+	{
+		// the oldest row of each user is kept
+		Version: 2,
+		Name:    "unique members, roles and room access",
+		Up: []string{
+			`DELETE FROM chat_server_member WHERE id NOT IN (SELECT MIN(id) FROM chat_server_member GROUP BY server_id, user_id)`,
+			`CREATE UNIQUE INDEX chat_server_member_server_id_user_id_key ON chat_server_member (server_id, user_id)`,
+			`DELETE FROM chat_server_role WHERE id NOT IN (SELECT MIN(id) FROM chat_server_role GROUP BY server_id, user_id)`,
+			`CREATE UNIQUE INDEX chat_server_role_server_id_user_id_key ON chat_server_role (server_id, user_id)`,
+			`DELETE FROM chat_room_role WHERE id NOT IN (SELECT MIN(id) FROM chat_room_role GROUP BY room_id, user_id)`,
+			`CREATE UNIQUE INDEX chat_room_role_room_id_user_id_key ON chat_room_role (room_id, user_id)`,
+			`DELETE FROM chat_room_access WHERE id NOT IN (SELECT MIN(id) FROM chat_room_access GROUP BY room_id, user_id)`,
+			`CREATE UNIQUE INDEX chat_room_access_room_id_user_id_key ON chat_room_access (room_id, user_id)`,
+		},
+		Down: []string{
+			`DROP INDEX chat_room_access_room_id_user_id_key`,
+			`DROP INDEX chat_room_role_room_id_user_id_key`,
+			`DROP INDEX chat_server_role_server_id_user_id_key`,
+			`DROP INDEX chat_server_member_server_id_user_id_key`,
+		},
+	},
+}
+
+// sqliteMigrationSession migrates in one transaction, started with BEGIN IMMEDIATE it holds
+// the write lock of the file until everything is applied. A failed migration rolls back the
+// ones applied before it in the same run.
+type sqliteMigrationSession struct {
+	tx *sql.Tx
+}
+
+func (store *SqliteStore) withMigrationLock(fn func(session migrationSession) error) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)`)
+		if err != nil {
+			return err
+		}
+		return fn(sqliteMigrationSession{tx: tx})
+	})
+}
+
+func (session sqliteMigrationSession) appliedMigrations() (map[int]int64, error) {
+	rows, err := session.tx.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]int64)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (session sqliteMigrationSession) applyMigration(migration Migration, up bool, now int64) error {
+	statements := migration.Down
+	if up {
+		statements = migration.Up
+	}
+	for _, statement := range statements {
+		if _, err := session.tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	var err error
+	if up {
+		_, err = session.tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, now)
+	} else {
+		_, err = session.tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	return err
+}
+
+func (store *SqliteStore) MigrateUp() ([]Migration, error) {
+	return migrateUp(store.withMigrationLock, sqliteMigrations)
+}
+
+func (store *SqliteStore) MigrateDown() (*Migration, error) {
+	return migrateDown(store.withMigrationLock, sqliteMigrations)
+}
+
+func (store *SqliteStore) MigrationStatus() ([]MigrationStatus, error) {
+	return migrationStatus(store.withMigrationLock, sqliteMigrations)
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"database/sql"
+	"voice-chat-server/models"
+)
+
+const roleColumns = "id, name, permissions"
+
+func roleFields(role *models.ChatRole) []interface{} {
+	return []interface{}{&role.Id, &role.Name, &role.Permissions}
+}
+
+func (store *SqliteStore) GetRole(id int64) (*models.ChatRole, error) {
+	var role models.ChatRole
+	err := store.DB.QueryRow("SELECT "+roleColumns+" FROM chat_role WHERE id = ?", id).Scan(roleFields(&role)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &role, nil
+}
+
+func (store *SqliteStore) ListRoles() ([]models.ChatRole, error) {
+	rows, err := store.DB.Query("SELECT " + roleColumns + " FROM chat_role ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := make([]models.ChatRole, 0)
+	for rows.Next() {
+		var role models.ChatRole
+		if err = rows.Scan(roleFields(&role)...); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (store *SqliteStore) CreateRole(role *models.ChatRole) error {
+	return insert(store.DB, "chat_role", roleColumns, &role.Id, roleFields(role)...)
+}
+
+const serverRoleColumns = "id, user_id, server_id, role_id"
+
+func (store *SqliteStore) GetServerRole(userId int64, serverId int64) (*models.ChatServerRole, error) {
+	var serverRole models.ChatServerRole
+	err := store.DB.QueryRow("SELECT "+serverRoleColumns+" FROM chat_server_role WHERE user_id = ? AND server_id = ? LIMIT 1",
+		userId, serverId).Scan(&serverRole.Id, &serverRole.UserId, &serverRole.ServerId, &serverRole.RoleId)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &serverRole, nil
+}
+
+func (store *SqliteStore) SetServerRole(userId int64, serverId int64, roleId int64) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		affected, err := rowsAffected(tx.Exec("UPDATE chat_server_role SET role_id = ? WHERE user_id = ? AND server_id = ?",
+			roleId, userId, serverId))
+		if err != nil || affected > 0 {
+			return err
+		}
+		return insert(tx, "chat_server_role", serverRoleColumns, nil, nil, userId, serverId, roleId)
+	})
+}
+
+const roomRoleColumns = "id, user_id, room_id, role_id"
+
+func roomRoleFields(roomRole *models.ChatRoomRole) []interface{} {
+	return []interface{}{&roomRole.Id, &roomRole.UserId, &roomRole.RoomId, &roomRole.RoleId}
+}
+
+func (store *SqliteStore) GetRoomRole(userId int64, roomId int64) (*models.ChatRoomRole, error) {
+	var roomRole models.ChatRoomRole
+	err := store.DB.QueryRow("SELECT "+roomRoleColumns+" FROM chat_room_role WHERE user_id = ? AND room_id = ? LIMIT 1",
+		userId, roomId).Scan(roomRoleFields(&roomRole)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &roomRole, nil
+}
+
+func (store *SqliteStore) SetRoomRole(userId int64, roomId int64, roleId int64) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		affected, err := rowsAffected(tx.Exec("UPDATE chat_room_role SET role_id = ? WHERE user_id = ? AND room_id = ?",
+			roleId, userId, roomId))
+		if err != nil || affected > 0 {
+			return err
+		}
+		return insert(tx, "chat_room_role", roomRoleColumns, nil, nil, userId, roomId, roleId)
+	})
+}
+
+func (store *SqliteStore) DeleteRoomRole(userId int64, roomId int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_room_role WHERE user_id = ? AND room_id = ?", userId, roomId)
+	return err
+}
+
+func (store *SqliteStore) ListRoomRolesOf(userId int64) ([]models.ChatRoomRole, error) {
+	rows, err := store.DB.Query("SELECT "+prefixed("rr", roomRoleColumns)+", "+prefixed("r", roomColumns)+", "+
+		prefixed("ro", roleColumns)+` FROM chat_room_role rr
+		JOIN chat_room r ON r.id = rr.room_id
+		JOIN chat_role ro ON ro.id = rr.role_id
+		WHERE rr.user_id = ? ORDER BY rr.id`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roomRoles := make([]models.ChatRoomRole, 0)
+	for rows.Next() {
+		var roomRole models.ChatRoomRole
+		roomRole.Room, roomRole.Role = &models.ChatRoom{}, &models.ChatRole{}
+		fields := append(roomRoleFields(&roomRole), roomFields(roomRole.Room)...)
+		if err = rows.Scan(append(fields, roleFields(roomRole.Role)...)...); err != nil {
+			return nil, err
+		}
+		roomRoles = append(roomRoles, roomRole)
+	}
+	return roomRoles, rows.Err()
+}
+
+const banColumns = "id, user_id, server_id, room_id, reason, created_by, create_at, expires"
+
+func banFields(ban *models.ChatBan) []interface{} {
+	return []interface{}{&ban.Id, &ban.UserId, &ban.ServerId, &ban.RoomId, &ban.Reason, &ban.CreatedBy,
+		&ban.CreateAt, &ban.Expires}
+}
+
+func (store *SqliteStore) selectBans(query string, args ...interface{}) ([]models.ChatBan, error) {
+	rows, err := store.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make([]models.ChatBan, 0)
+	for rows.Next() {
+		var ban models.ChatBan
+		if err = rows.Scan(banFields(&ban)...); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (store *SqliteStore) GetBan(id int64) (*models.ChatBan, error) {
+	var ban models.ChatBan
+	err := store.DB.QueryRow("SELECT "+banColumns+" FROM chat_ban WHERE id = ?", id).Scan(banFields(&ban)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &ban, nil
+}
+
+func (store *SqliteStore) ListBans(serverId int64, now int64) ([]models.ChatBan, error) {
+	return store.selectBans("SELECT "+banColumns+` FROM chat_ban
+		WHERE server_id = ? AND (expires = 0 OR expires > ?) ORDER BY id`, serverId, now)
+}
+
+func (store *SqliteStore) ListBansOf(userId int64, serverId int64, roomId int64) ([]models.ChatBan, error) {
+	return store.selectBans("SELECT "+banColumns+` FROM chat_ban
+		WHERE user_id = ? AND server_id = ? AND (room_id = 0 OR room_id = ?)`, userId, serverId, roomId)
+}
+
+func (store *SqliteStore) CreateBan(ban *models.ChatBan) error {
+	return insert(store.DB, "chat_ban", banColumns, &ban.Id, banFields(ban)...)
+}
+
+func (store *SqliteStore) DeleteBan(id int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_ban WHERE id = ?", id)
+	return err
+}
+
+const inviteColumns = "id, code, server_id, room_id, role_id, max_uses, uses, created_by, create_at, expires, revoked"
+
+func inviteFields(invite *models.ChatInvite) []interface{} {
+	return []interface{}{&invite.Id, &invite.Code, &invite.ServerId, &invite.RoomId, &invite.RoleId, &invite.MaxUses,
+		&invite.Uses, &invite.CreatedBy, &invite.CreateAt, &invite.Expires, &invite.Revoked}
+}
+
+func (store *SqliteStore) getInvite(where string, args ...interface{}) (*models.ChatInvite, error) {
+	var invite models.ChatInvite
+	err := store.DB.QueryRow("SELECT "+inviteColumns+" FROM chat_invite WHERE "+where, args...).
+		Scan(inviteFields(&invite)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &invite, nil
+}
+
+func (store *SqliteStore) GetInvite(id int64) (*models.ChatInvite, error) {
+	return store.getInvite("id = ?", id)
+}
+
+func (store *SqliteStore) GetInviteByCode(code string) (*models.ChatInvite, error) {
+	return store.getInvite("code = ?", code)
+}
+
+func (store *SqliteStore) ListInvites(serverId int64) ([]models.ChatInvite, error) {
+	rows, err := store.DB.Query("SELECT "+inviteColumns+" FROM chat_invite WHERE server_id = ? ORDER BY id", serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invites := make([]models.ChatInvite, 0)
+	for rows.Next() {
+		var invite models.ChatInvite
+		if err = rows.Scan(inviteFields(&invite)...); err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+func (store *SqliteStore) CreateInvite(invite *models.ChatInvite) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM chat_invite WHERE code = ?", invite.Code).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrConflict
+		}
+		return insert(tx, "chat_invite", inviteColumns, &invite.Id, inviteFields(invite)...)
+	})
+}
+
+func (store *SqliteStore) RevokeInvite(id int64) error {
+	_, err := store.DB.Exec("UPDATE chat_invite SET revoked = 1 WHERE id = ?", id)
+	return err
+}
+
+func (store *SqliteStore) UseInvite(invite *models.ChatInvite, now int64) (bool, error) {
+	var used bool
+	err := store.inTransaction(func(tx *sql.Tx) error {
+		affected, err := rowsAffected(tx.Exec(`UPDATE chat_invite SET uses = uses + 1
+			WHERE id = ? AND revoked = 0 AND (max_uses = 0 OR uses < max_uses) AND (expires = 0 OR expires > ?)`,
+			invite.Id, now))
+		if err != nil || affected == 0 {
+			return err
+		}
+		used = true
+		return tx.QueryRow("SELECT uses FROM chat_invite WHERE id = ?", invite.Id).Scan(&invite.Uses)
+	})
+	return used, err
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"database/sql"
+	"voice-chat-server/models"
+)
+
+const serverColumns = "id, name, description, position, public"
+
+func serverFields(server *models.ChatServer) []interface{} {
+	return []interface{}{&server.Id, &server.Name, &server.Description, &server.Position, &server.Public}
+}
+
+func selectServers(q querier, query string, args ...interface{}) ([]models.ChatServer, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	servers := make([]models.ChatServer, 0)
+	for rows.Next() {
+		var server models.ChatServer
+		if err = rows.Scan(serverFields(&server)...); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return servers, rows.Err()
+}
+
+func (store *SqliteStore) GetServer(id int64) (*models.ChatServer, error) {
+	var server models.ChatServer
+	err := store.DB.QueryRow("SELECT "+serverColumns+" FROM chat_server WHERE id = ?", id).Scan(serverFields(&server)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &server, nil
+}
+
+func (store *SqliteStore) ListServers() ([]models.ChatServer, error) {
+	return selectServers(store.DB, "SELECT "+serverColumns+" FROM chat_server ORDER BY position, id")
+}
+
+func (store *SqliteStore) ListServersOf(userId int64) ([]models.ChatServer, error) {
+	return selectServers(store.DB, "SELECT "+prefixed("s", serverColumns)+` FROM chat_server s
+		JOIN chat_server_member m ON m.server_id = s.id AND m.user_id = ?
+		ORDER BY s.position, s.id`, userId)
+}
+
+func (store *SqliteStore) CreateServer(server *models.ChatServer) error {
+	return insert(store.DB, "chat_server", serverColumns, &server.Id, serverFields(server)...)
+}
+
+func (store *SqliteStore) UpdateServer(server *models.ChatServer) error {
+	_, err := store.DB.Exec("UPDATE chat_server SET name = ?, description = ?, position = ?, public = ? WHERE id = ?",
+		server.Name, server.Description, server.Position, server.Public, server.Id)
+	return err
+}
+
+func (store *SqliteStore) DeleteServer(id int64) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		var roomIds []int64
+		rows, err := tx.Query("SELECT id FROM chat_room WHERE server_id = ?", id)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var roomId int64
+			if err = rows.Scan(&roomId); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			roomIds = append(roomIds, roomId)
+		}
+		if err = rows.Close(); err != nil {
+			return err
+		}
+		for _, roomId := range roomIds {
+			if err = deleteSqliteRoom(tx, roomId); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("DELETE FROM chat_server WHERE id = ?", id)
+		return err
+	})
+}
+
+const memberColumns = "id, server_id, user_id, nickname, joined_at"
+
+func memberFields(member *models.ChatServerMember) []interface{} {
+	return []interface{}{&member.Id, &member.ServerId, &member.UserId, &member.Nickname, &member.JoinedAt}
+}
+
+func (store *SqliteStore) GetMember(serverId int64, userId int64) (*models.ChatServerMember, error) {
+	var member models.ChatServerMember
+	err := store.DB.QueryRow("SELECT "+memberColumns+" FROM chat_server_member WHERE server_id = ? AND user_id = ? LIMIT 1",
+		serverId, userId).Scan(memberFields(&member)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &member, nil
+}
+
+func (store *SqliteStore) ListMembers(serverId int64) ([]models.ChatServerMember, error) {
+	rows, err := store.DB.Query("SELECT "+prefixed("m", memberColumns)+", "+prefixed("u", userColumns)+
+		` FROM chat_server_member m JOIN chat_user u ON u.id = m.user_id
+		WHERE m.server_id = ? ORDER BY m.id`, serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]models.ChatServerMember, 0)
+	for rows.Next() {
+		var member models.ChatServerMember
+		member.User = &models.ChatUser{}
+		if err = rows.Scan(append(memberFields(&member), userFields(member.User)...)...); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (store *SqliteStore) CreateMember(member *models.ChatServerMember) error {
+	return insertOrIgnore(store.DB, "chat_server_member", memberColumns, &member.Id, memberFields(member)...)
+}
+
+func (store *SqliteStore) DeleteMember(serverId int64, userId int64) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM chat_server_member WHERE server_id = ? AND user_id = ?", serverId, userId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM chat_server_role WHERE server_id = ? AND user_id = ?", serverId, userId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM chat_room_role WHERE user_id = ?
+			AND room_id IN (SELECT id FROM chat_room WHERE server_id = ?)`, userId, serverId)
+		return err
+	})
+}
+
+func (store *SqliteStore) SetNickname(serverId int64, userId int64, nickname string) error {
+	affected, err := rowsAffected(store.DB.Exec("UPDATE chat_server_member SET nickname = ? WHERE server_id = ? AND user_id = ?",
+		nickname, serverId, userId))
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const roomColumns = "id, name, description, media_mode, mixing, position, capacity, max_speakers, waiting_queue, " +
+	"password, private, server_id"
+
+func roomFields(room *models.ChatRoom) []interface{} {
+	return []interface{}{&room.Id, &room.Name, &room.Description, &room.MediaMode, &room.Mixing, &room.Position,
+		&room.Capacity, &room.MaxSpeakers, &room.WaitingQueue, &room.Password, &room.Private, &room.ServerId}
+}
+
+func (store *SqliteStore) GetRoom(id int64) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := store.DB.QueryRow("SELECT "+roomColumns+" FROM chat_room WHERE id = ?", id).Scan(roomFields(&room)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &room, nil
+}
+
+func (store *SqliteStore) ListRooms(serverId int64) ([]models.ChatRoom, error) {
+	rows, err := store.DB.Query("SELECT "+prefixed("r", roomColumns)+", "+prefixed("s", serverColumns)+
+		` FROM chat_room r JOIN chat_server s ON s.id = r.server_id
+		WHERE r.server_id = ? ORDER BY r.position, r.id`, serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rooms := make([]models.ChatRoom, 0)
+	for rows.Next() {
+		var room models.ChatRoom
+		room.Server = &models.ChatServer{}
+		if err = rows.Scan(append(roomFields(&room), serverFields(room.Server)...)...); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+func (store *SqliteStore) CreateRoom(room *models.ChatRoom) error {
+	return insert(store.DB, "chat_room", roomColumns, &room.Id, roomFields(room)...)
+}
+
+func (store *SqliteStore) UpdateRoom(room *models.ChatRoom) error {
+	_, err := store.DB.Exec(`UPDATE chat_room SET name = ?, description = ?, media_mode = ?, mixing = ?, position = ?,
+		capacity = ?, max_speakers = ?, waiting_queue = ?, password = ?, private = ? WHERE id = ?`,
+		room.Name, room.Description, room.MediaMode, room.Mixing, room.Position, room.Capacity, room.MaxSpeakers,
+		room.WaitingQueue, room.Password, room.Private, room.Id)
+	return err
+}
+
+func (store *SqliteStore) DeleteRoom(id int64) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		return deleteSqliteRoom(tx, id)
+	})
+}
+
+// deleteSqliteRoom removes what refers to the room without cascading, like deleteRoom does
+// in Postgres.
+func deleteSqliteRoom(tx *sql.Tx, roomId int64) error {
+	for _, table := range []string{"chat_user_conn_stats", "chat_ban", "chat_invite"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE room_id = ?", roomId); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM chat_room WHERE id = ?", roomId)
+	return err
+}
+
+const roomAccessColumns = "id, room_id, user_id, created_by, create_at"
+
+func roomAccessFields(access *models.ChatRoomAccess) []interface{} {
+	return []interface{}{&access.Id, &access.RoomId, &access.UserId, &access.CreatedBy, &access.CreateAt}
+}
+
+func (store *SqliteStore) HasRoomAccess(roomId int64, userId int64) (bool, error) {
+	var count int
+	err := store.DB.QueryRow("SELECT COUNT(*) FROM chat_room_access WHERE room_id = ? AND user_id = ?",
+		roomId, userId).Scan(&count)
+	return count > 0, err
+}
+
+func (store *SqliteStore) ListRoomAccess(roomId int64) ([]models.ChatRoomAccess, error) {
+	rows, err := store.DB.Query("SELECT "+roomAccessColumns+" FROM chat_room_access WHERE room_id = ? ORDER BY id", roomId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	access := make([]models.ChatRoomAccess, 0)
+	for rows.Next() {
+		var a models.ChatRoomAccess
+		if err = rows.Scan(roomAccessFields(&a)...); err != nil {
+			return nil, err
+		}
+		access = append(access, a)
+	}
+	return access, rows.Err()
+}
+
+func (store *SqliteStore) CreateRoomAccess(access *models.ChatRoomAccess) error {
+	return insertOrIgnore(store.DB, "chat_room_access", roomAccessColumns, &access.Id, roomAccessFields(access)...)
+}
+
+func (store *SqliteStore) DeleteRoomAccess(roomId int64, userId int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_room_access WHERE room_id = ? AND user_id = ?", roomId, userId)
+	return err
+}
+
+const connStatsColumns = "id, user_id, room_id"
+
+func (store *SqliteStore) ListConnStats(userId int64, roomId int64) ([]models.ChatUserConnStats, error) {
+	rows, err := store.DB.Query("SELECT "+connStatsColumns+" FROM chat_user_conn_stats WHERE user_id = ? AND room_id = ?",
+		userId, roomId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []models.ChatUserConnStats
+	for rows.Next() {
+		var s models.ChatUserConnStats
+		if err = rows.Scan(&s.Id, &s.UserId, &s.RoomId); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+func (store *SqliteStore) CreateConnStats(stats *models.ChatUserConnStats) error {
+	return insert(store.DB, "chat_user_conn_stats", connStatsColumns, nil, stats.Id, stats.UserId, stats.RoomId)
+}
+
+func (store *SqliteStore) DeleteConnStats(id string) error {
+	_, err := store.DB.Exec("DELETE FROM chat_user_conn_stats WHERE id = ?", id)
+	return err
+}
+
+func (store *SqliteStore) DeleteUserConnStats(userId int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_user_conn_stats WHERE user_id = ?", userId)
+	return err
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"database/sql"
+	_ "modernc.org/sqlite"
+	"strings"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+)
+
+// SqliteStore keeps everything in a single SQLite file through the pure Go driver, the tables
+// mirror the ones go-pg creates in Postgres.
+type SqliteStore struct {
+	DB   *sql.DB
+	Path string
+}
+
+var _ Store = (*SqliteStore)(nil)
+
+// querier is implemented by both sql.DB and sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Open opens the database file, creating it when missing. Path may carry query parameters
+// of the driver after a '?'.
+func (store *SqliteStore) Open() error {
+	dsn := store.Path + "?"
+	if strings.Contains(store.Path, "?") {
+		dsn = store.Path + "&"
+	}
+	dsn += "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return err
+	}
+	// SQLite writes one at a time anyway, a single connection also keeps in-memory
+	// databases from being opened once per connection
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return err
+	}
+	store.DB = db
+	logger.Logger.Infof("SQLite opened, path: %s", store.Path)
+	return nil
+}
+
+func (store *SqliteStore) Close() error {
+	err := store.DB.Close()
+	logger.Logger.Info("SQLite closed")
+	return err
+}
+
+func sqlError(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// inTransaction runs fn in a transaction. With a single connection fn must only use tx.
+func (store *SqliteStore) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// insert adds a row, values are in the order of the columns. A zero id lets SQLite pick the
+// next one, which is written back.
+func insert(q querier, table string, columns string, id *int64, values ...interface{}) error {
+	return insertRow(q, "INSERT", table, columns, id, values...)
+}
+
+// insertOrIgnore skips rows breaking a unique constraint, the id is only set for inserted rows.
+func insertOrIgnore(q querier, table string, columns string, id *int64, values ...interface{}) error {
+	return insertRow(q, "INSERT OR IGNORE", table, columns, id, values...)
+}
+
+func insertRow(q querier, verb string, table string, columns string, id *int64, values ...interface{}) error {
+	if id != nil && *id == 0 {
+		values[0] = nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	result, err := q.Exec(verb+" INTO "+table+" ("+columns+") VALUES ("+placeholders+")", values...)
+	if err != nil {
+		return err
+	}
+	if id != nil && *id == 0 {
+		affected, err := result.RowsAffected()
+		if err != nil || affected == 0 {
+			return err
+		}
+		*id, err = result.LastInsertId()
+		return err
+	}
+	return nil
+}
+
+// prefixed qualifies every column with the alias of its table.
+func prefixed(alias string, columns string) string {
+	return alias + "." + strings.Replace(columns, ", ", ", "+alias+".", -1)
+}
+
+func rowsAffected(result sql.Result, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+const userColumns = "id, name, user_name, password, disabled"
+
+func userFields(user *models.ChatUser) []interface{} {
+	return []interface{}{&user.Id, &user.Name, &user.UserName, &user.Password, &user.Disabled}
+}
+
+func (store *SqliteStore) getUser(where string, args ...interface{}) (*models.ChatUser, error) {
+	var user models.ChatUser
+	err := store.DB.QueryRow("SELECT "+userColumns+" FROM chat_user WHERE "+where, args...).Scan(userFields(&user)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &user, nil
+}
+
+func (store *SqliteStore) GetUser(id int64) (*models.ChatUser, error) {
+	return store.getUser("id = ?", id)
+}
+
+func (store *SqliteStore) GetUserByUsername(username string) (*models.ChatUser, error) {
+	return store.getUser("user_name = ?", username)
+}
+
+func (store *SqliteStore) ListUsers() ([]models.ChatUser, error) {
+	rows, err := store.DB.Query("SELECT " + userColumns + " FROM chat_user ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]models.ChatUser, 0)
+	for rows.Next() {
+		var user models.ChatUser
+		if err = rows.Scan(userFields(&user)...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (store *SqliteStore) CreateUser(user *models.ChatUser) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM chat_user WHERE user_name = ?", user.UserName).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrConflict
+		}
+		return insert(tx, "chat_user", userColumns, &user.Id, userFields(user)...)
+	})
+}
+
+func (store *SqliteStore) UpdateUser(user *models.ChatUser) error {
+	_, err := store.DB.Exec("UPDATE chat_user SET name = ?, user_name = ?, password = ?, disabled = ? WHERE id = ?",
+		user.Name, user.UserName, user.Password, user.Disabled, user.Id)
+	return err
+}
+
+func (store *SqliteStore) DeleteUser(id int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_user WHERE id = ?", id)
+	return err
+}
+
+const sessionColumns = "user_name, token, create_at, expires"
+
+func sessionFields(session *models.UserSession) []interface{} {
+	return []interface{}{&session.UserName, &session.Token, &session.CreateAt, &session.Expires}
+}
+
+func (store *SqliteStore) getSession(where string, args ...interface{}) (*models.UserSession, error) {
+	var session models.UserSession
+	err := store.DB.QueryRow("SELECT "+sessionColumns+" FROM chat_user_session WHERE "+where, args...).
+		Scan(sessionFields(&session)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &session, nil
+}
+
+func (store *SqliteStore) GetSessionByToken(token string) (*models.UserSession, error) {
+	return store.getSession("token = ?", token)
+}
+
+func (store *SqliteStore) GetSessionByUserName(username string) (*models.UserSession, error) {
+	return store.getSession("user_name = ?", username)
+}
+
+func (store *SqliteStore) ListSessions() ([]models.UserSession, error) {
+	rows, err := store.DB.Query("SELECT " + sessionColumns + " FROM chat_user_session ORDER BY user_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := make([]models.UserSession, 0)
+	for rows.Next() {
+		var session models.UserSession
+		if err = rows.Scan(sessionFields(&session)...); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (store *SqliteStore) ReplaceSession(session *models.UserSession) error {
+	return store.inTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM chat_user_session WHERE user_name = ?", session.UserName)
+		if err != nil {
+			return err
+		}
+		return insert(tx, "chat_user_session", sessionColumns, nil, sessionFields(session)...)
+	})
+}
+
+func (store *SqliteStore) DeleteSessions(username string) error {
+	_, err := store.DB.Exec("DELETE FROM chat_user_session WHERE user_name = ?", username)
+	return err
+}
+
+func (store *SqliteStore) DeleteExpiredSessions(now int64) (int, error) {
+	return rowsAffected(store.DB.Exec("DELETE FROM chat_user_session WHERE expires < ?", now))
+}
+
+const refreshTokenColumns = "id, user_name, family, token_hash, create_at, expires, used, revoked"
+
+func refreshTokenFields(token *models.RefreshToken) []interface{} {
+	return []interface{}{&token.Id, &token.UserName, &token.Family, &token.TokenHash, &token.CreateAt,
+		&token.Expires, &token.Used, &token.Revoked}
+}
+
+func (store *SqliteStore) GetRefreshToken(tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := store.DB.QueryRow("SELECT "+refreshTokenColumns+" FROM chat_refresh_token WHERE token_hash = ?", tokenHash).
+		Scan(refreshTokenFields(&token)...)
+	if err != nil {
+		return nil, sqlError(err)
+	}
+	return &token, nil
+}
+
+func (store *SqliteStore) CreateRefreshToken(token *models.RefreshToken) error {
+	return insert(store.DB, "chat_refresh_token", refreshTokenColumns, &token.Id, refreshTokenFields(token)...)
+}
+
+func (store *SqliteStore) UseRefreshToken(id int64) (bool, error) {
+	affected, err := rowsAffected(store.DB.Exec("UPDATE chat_refresh_token SET used = 1 WHERE id = ? AND used = 0", id))
+	return affected > 0, err
+}
+
+func (store *SqliteStore) RevokeRefreshTokens(username string) error {
+	_, err := store.DB.Exec("UPDATE chat_refresh_token SET revoked = 1 WHERE user_name = ? AND revoked = 0", username)
+	return err
+}
+
+func (store *SqliteStore) RevokeTokenFamily(family string) error {
+	_, err := store.DB.Exec("UPDATE chat_refresh_token SET revoked = 1 WHERE family = ?", family)
+	return err
+}
+
+func (store *SqliteStore) DeleteExpiredRefreshTokens(now int64) error {
+	_, err := store.DB.Exec("DELETE FROM chat_refresh_token WHERE expires < ?", now)
+	return err
+}
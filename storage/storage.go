@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"errors"
+	"voice-chat-server/models"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record already exists")
+)
+
+// Timestamps passed to the repositories are in ms like the ones of the models.
+
+type UserRepository interface {
+	GetUser(id int64) (*models.ChatUser, error)
+	GetUserByUsername(username string) (*models.ChatUser, error)
+	ListUsers() ([]models.ChatUser, error)
+	// CreateUser assigns the next free id unless the user has one, a taken username fails
+	// with ErrConflict.
+	CreateUser(user *models.ChatUser) error
+	UpdateUser(user *models.ChatUser) error
+	DeleteUser(id int64) error
+}
+
+type SessionRepository interface {
+	GetSessionByToken(token string) (*models.UserSession, error)
+	GetSessionByUserName(username string) (*models.UserSession, error)
+	// ListSessions returns the sessions ordered by user name.
+	ListSessions() ([]models.UserSession, error)
+	// ReplaceSession stores the session in place of the current session of its user.
+	ReplaceSession(session *models.UserSession) error
+	DeleteSessions(username string) error
+	DeleteExpiredSessions(now int64) (int, error)
+
+	GetRefreshToken(tokenHash string) (*models.RefreshToken, error)
+	CreateRefreshToken(token *models.RefreshToken) error
+	// UseRefreshToken marks the token as used and reports false if it was used before.
+	UseRefreshToken(id int64) (bool, error)
+	RevokeRefreshTokens(username string) error
+	RevokeTokenFamily(family string) error
+	DeleteExpiredRefreshTokens(now int64) error
+}
+
+// ServerRepository keeps the servers and their members. Lists of servers are ordered by
+// position and id.
+type ServerRepository interface {
+	GetServer(id int64) (*models.ChatServer, error)
+	ListServers() ([]models.ChatServer, error)
+	ListServersOf(userId int64) ([]models.ChatServer, error)
+	// CreateServer assigns the next free id unless the server has one.
+	CreateServer(server *models.ChatServer) error
+	UpdateServer(server *models.ChatServer) error
+	// DeleteServer removes the server together with its rooms and everything bound to them.
+	DeleteServer(id int64) error
+
+	GetMember(serverId int64, userId int64) (*models.ChatServerMember, error)
+	// ListMembers loads the user of every member.
+	ListMembers(serverId int64) ([]models.ChatServerMember, error)
+	// CreateMember adds the membership unless the user already is a member of the server.
+	CreateMember(member *models.ChatServerMember) error
+	// DeleteMember drops the membership together with the roles of the user on the server
+	// and its rooms.
+	DeleteMember(serverId int64, userId int64) error
+	// SetNickname fails with ErrNotFound if the user is not a member of the server.
+	SetNickname(serverId int64, userId int64, nickname string) error
+}
+
+// RoomRepository keeps the rooms and the access lists of private rooms. Lists of rooms are
+// ordered by position and id.
+type RoomRepository interface {
+	GetRoom(id int64) (*models.ChatRoom, error)
+	// ListRooms loads the server of every room.
+	ListRooms(serverId int64) ([]models.ChatRoom, error)
+	// CreateRoom assigns the next free id unless the room has one.
+	CreateRoom(room *models.ChatRoom) error
+	UpdateRoom(room *models.ChatRoom) error
+	// DeleteRoom removes the room together with its connection records, bans, invites,
+	// access list and roles.
+	DeleteRoom(id int64) error
+
+	HasRoomAccess(roomId int64, userId int64) (bool, error)
+	ListRoomAccess(roomId int64) ([]models.ChatRoomAccess, error)
+	// CreateRoomAccess lets the user into the room unless it already has access.
+	CreateRoomAccess(access *models.ChatRoomAccess) error
+	DeleteRoomAccess(roomId int64, userId int64) error
+}
+
+type RoleRepository interface {
+	GetRole(id int64) (*models.ChatRole, error)
+	ListRoles() ([]models.ChatRole, error)
+	CreateRole(role *models.ChatRole) error
+
+	GetServerRole(userId int64, serverId int64) (*models.ChatServerRole, error)
+	// SetServerRole replaces the role of the user on the server or assigns a first one.
+	SetServerRole(userId int64, serverId int64, roleId int64) error
+	GetRoomRole(userId int64, roomId int64) (*models.ChatRoomRole, error)
+	SetRoomRole(userId int64, roomId int64, roleId int64) error
+	DeleteRoomRole(userId int64, roomId int64) error
+	// ListRoomRolesOf loads the room and the role of every room override of the user.
+	ListRoomRolesOf(userId int64) ([]models.ChatRoomRole, error)
+}
+
+type BanRepository interface {
+	GetBan(id int64) (*models.ChatBan, error)
+	// ListBans returns the bans of the server that have not expired at now.
+	ListBans(serverId int64, now int64) ([]models.ChatBan, error)
+	// ListBansOf returns the bans of the user on the whole server or on the room, expired
+	// ones included.
+	ListBansOf(userId int64, serverId int64, roomId int64) ([]models.ChatBan, error)
+	CreateBan(ban *models.ChatBan) error
+	DeleteBan(id int64) error
+}
+
+type InviteRepository interface {
+	GetInvite(id int64) (*models.ChatInvite, error)
+	GetInviteByCode(code string) (*models.ChatInvite, error)
+	ListInvites(serverId int64) ([]models.ChatInvite, error)
+	CreateInvite(invite *models.ChatInvite) error
+	RevokeInvite(id int64) error
+	// UseInvite counts one use of the invite unless it is revoked, expired or used up at now,
+	// which is reported by false. The uses of the invite are updated.
+	UseInvite(invite *models.ChatInvite, now int64) (bool, error)
+}
+
+type ConnStatsRepository interface {
+	ListConnStats(userId int64, roomId int64) ([]models.ChatUserConnStats, error)
+	CreateConnStats(stats *models.ChatUserConnStats) error
+	DeleteConnStats(id string) error
+	DeleteUserConnStats(userId int64) error
+}
+
+// Store is a storage backend holding every repository.
+type Store interface {
+	UserRepository
+	SessionRepository
+	ServerRepository
+	RoomRepository
+	RoleRepository
+	BanRepository
+	InviteRepository
+	ConnStatsRepository
+	Migrator
+	Close() error
+}
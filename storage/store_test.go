@@ -0,0 +1,641 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"voice-chat-server/logger"
+	"voice-chat-server/models"
+)
+
+// postgresTestEnv names a Postgres URL the suite also runs against, the database has to be
+// empty as the suite counts the rows it creates.
+const postgresTestEnv = "VOICE_CHAT_TEST_POSTGRES"
+
+func TestMain(m *testing.M) {
+	logger.InitQuiet()
+	os.Exit(m.Run())
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv(postgresTestEnv)
+	if url == "" {
+		t.Skipf("%s is not set", postgresTestEnv)
+	}
+	testStore(t, func(t *testing.T) Store {
+		store := &PostgresStore{URL: url}
+		if err := store.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			for {
+				if _, err := store.MigrateDown(); err != nil {
+					if err != ErrNoMigration {
+						t.Error(err)
+					}
+					break
+				}
+			}
+			_ = store.Close()
+		})
+		if _, err := store.MigrateUp(); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func openSqlite(t *testing.T) *SqliteStore {
+	store := &SqliteStore{Path: filepath.Join(t.TempDir(), "chat.db")}
+	if err := store.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	if _, err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSqliteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return openSqlite(t)
+	})
+}
+
+func TestSqliteMigrateDownAndUp(t *testing.T) {
+	store := openSqlite(t)
+	createUser(t, store, "alice")
+
+	status, err := store.MigrationStatus()
+	check(t, err)
+	for _, migration := range status {
+		if migration.AppliedAt == 0 {
+			t.Fatalf("migration %d is pending", migration.Version)
+		}
+	}
+	// every migration can be reverted and applied again
+	for i := len(status) - 1; i >= 0; i-- {
+		reverted, err := store.MigrateDown()
+		check(t, err)
+		if reverted.Version != status[i].Version {
+			t.Fatalf("expected migration %d to be reverted, got %d", status[i].Version, reverted.Version)
+		}
+	}
+	_, err = store.MigrateDown()
+	expectErr(t, ErrNoMigration, err)
+	applied, err := store.MigrateUp()
+	check(t, err)
+	if len(applied) != len(status) {
+		t.Fatalf("expected %d migrations, got %d", len(status), len(applied))
+	}
+	applied, err = store.MigrateUp()
+	check(t, err)
+	if len(applied) != 0 {
+		t.Fatalf("expected nothing to migrate, got %d", len(applied))
+	}
+
+	users, err := store.ListUsers()
+	check(t, err)
+	if len(users) != 0 {
+		t.Fatalf("expected the tables to be recreated empty, got %+v", users)
+	}
+	createUser(t, store, "alice")
+}
+
+func TestSqliteMigrationRemovesDuplicates(t *testing.T) {
+	store := openSqlite(t)
+	_, err := store.MigrateDown()
+	check(t, err)
+	_, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+	for i := 0; i < 2; i++ {
+		check(t, store.CreateMember(&models.ChatServerMember{ServerId: room.ServerId, UserId: alice.Id, Nickname: fmt.Sprint(i), JoinedAt: now()}))
+		check(t, store.CreateRoomAccess(&models.ChatRoomAccess{RoomId: room.Id, UserId: alice.Id, CreateAt: now()}))
+	}
+
+	_, err = store.MigrateUp()
+	check(t, err)
+	members, err := store.ListMembers(room.ServerId)
+	check(t, err)
+	if len(members) != 1 || members[0].Nickname != "0" {
+		t.Fatalf("expected the first membership only, got %+v", members)
+	}
+	access, err := store.ListRoomAccess(room.Id)
+	check(t, err)
+	if len(access) != 1 {
+		t.Fatalf("expected one room access, got %+v", access)
+	}
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// testStore runs the same checks against every backend, each one on a fresh store.
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store Store)
+	}{
+		{"users", testUsers},
+		{"concurrent creates", testConcurrentCreates},
+		{"sessions", testSessions},
+		{"refresh tokens", testRefreshTokens},
+		{"servers and rooms", testServersAndRooms},
+		{"members", testMembers},
+		{"roles", testRoles},
+		{"room access", testRoomAccess},
+		{"bans", testBans},
+		{"invites", testInvites},
+		{"conn stats", testConnStats},
+		{"delete server", testDeleteServer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, open(t))
+		})
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectErr(t *testing.T, expected error, err error) {
+	t.Helper()
+	if err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+}
+
+func createUser(t *testing.T, store Store, username string) *models.ChatUser {
+	t.Helper()
+	user := &models.ChatUser{Name: username, UserName: username, Password: "hash"}
+	check(t, store.CreateUser(user))
+	return user
+}
+
+// createRoom creates a server holding one room.
+func createRoom(t *testing.T, store Store) (*models.ChatServer, *models.ChatRoom) {
+	t.Helper()
+	server := &models.ChatServer{Name: "server", Description: "server"}
+	check(t, store.CreateServer(server))
+	room := &models.ChatRoom{Name: "room", Description: "room", ServerId: server.Id}
+	check(t, store.CreateRoom(room))
+	return server, room
+}
+
+func createRole(t *testing.T, store Store, id int64, name string) {
+	t.Helper()
+	check(t, store.CreateRole(&models.ChatRole{Id: id, Name: name, Permissions: models.PermissionConnect}))
+}
+
+func testUsers(t *testing.T, store Store) {
+	alice := createUser(t, store, "alice")
+	bob := createUser(t, store, "bob")
+	if alice.Id == 0 || bob.Id == alice.Id {
+		t.Fatalf("expected distinct ids, got %d and %d", alice.Id, bob.Id)
+	}
+	expectErr(t, ErrConflict, store.CreateUser(&models.ChatUser{Name: "other", UserName: "alice", Password: "hash"}))
+
+	alice.Name = "Alice"
+	alice.Disabled = true
+	check(t, store.UpdateUser(alice))
+	loaded, err := store.GetUserByUsername("alice")
+	check(t, err)
+	if loaded.Id != alice.Id || loaded.Name != "Alice" || !loaded.Disabled {
+		t.Fatalf("unexpected user %+v", loaded)
+	}
+
+	check(t, store.DeleteUser(bob.Id))
+	_, err = store.GetUser(bob.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetUserByUsername("bob")
+	expectErr(t, ErrNotFound, err)
+	users, err := store.ListUsers()
+	check(t, err)
+	if len(users) != 1 || users[0].Id != alice.Id {
+		t.Fatalf("expected only alice, got %+v", users)
+	}
+}
+
+// testConcurrentCreates creates users, servers and rooms at once, each has to get its own id.
+func testConcurrentCreates(t *testing.T, store Store) {
+	// rows created with an id, like the defaults, must not be handed out again
+	check(t, store.CreateUser(&models.ChatUser{Id: 1, Name: "admin", UserName: "admin", Password: "hash"}))
+	check(t, store.CreateServer(&models.ChatServer{Id: 1, Name: "server", Description: "server"}))
+
+	const count = 20
+	users := make([]*models.ChatUser, count)
+	servers := make([]*models.ChatServer, count)
+	rooms := make([]*models.ChatRoom, count)
+	errs := make(chan error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			username := fmt.Sprintf("user-%d", i)
+			users[i] = &models.ChatUser{Name: username, UserName: username, Password: "hash"}
+			servers[i] = &models.ChatServer{Name: "server", Description: "server"}
+			rooms[i] = &models.ChatRoom{Name: "room", Description: "room", ServerId: 1}
+			for _, err := range []error{store.CreateUser(users[i]), store.CreateServer(servers[i]), store.CreateRoom(rooms[i])} {
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	ids := map[string]map[int64]bool{"user": {1: true}, "server": {1: true}, "room": {}}
+	for i := 0; i < count; i++ {
+		for kind, id := range map[string]int64{"user": users[i].Id, "server": servers[i].Id, "room": rooms[i].Id} {
+			if id == 0 || ids[kind][id] {
+				t.Fatalf("%s %d got the id %d twice", kind, i, id)
+			}
+			ids[kind][id] = true
+		}
+	}
+	loaded, err := store.ListUsers()
+	check(t, err)
+	if len(loaded) != count+1 {
+		t.Fatalf("expected %d users, got %d", count+1, len(loaded))
+	}
+}
+
+func testSessions(t *testing.T, store Store) {
+	created := now()
+	check(t, store.ReplaceSession(&models.UserSession{UserName: "bob", Token: "bob", CreateAt: created, Expires: created - 1}))
+	check(t, store.ReplaceSession(&models.UserSession{UserName: "alice", Token: "first", CreateAt: created, Expires: created + 60000}))
+	check(t, store.ReplaceSession(&models.UserSession{UserName: "alice", Token: "second", CreateAt: created, Expires: created + 60000}))
+
+	// the second session replaces the first one
+	_, err := store.GetSessionByToken("first")
+	expectErr(t, ErrNotFound, err)
+	session, err := store.GetSessionByUserName("alice")
+	check(t, err)
+	if session.Token != "second" {
+		t.Fatalf("expected the second session, got %s", session.Token)
+	}
+	sessions, err := store.ListSessions()
+	check(t, err)
+	if len(sessions) != 2 || sessions[0].UserName != "alice" || sessions[1].UserName != "bob" {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	deleted, err := store.DeleteExpiredSessions(created)
+	check(t, err)
+	if deleted != 1 {
+		t.Fatalf("expected 1 expired session, got %d", deleted)
+	}
+	_, err = store.GetSessionByUserName("bob")
+	expectErr(t, ErrNotFound, err)
+
+	check(t, store.DeleteSessions("alice"))
+	_, err = store.GetSessionByToken("second")
+	expectErr(t, ErrNotFound, err)
+}
+
+func testRefreshTokens(t *testing.T, store Store) {
+	created := now()
+	token := &models.RefreshToken{UserName: "alice", Family: "family", TokenHash: "hash", CreateAt: created, Expires: created + 60000}
+	check(t, store.CreateRefreshToken(token))
+	if token.Id == 0 {
+		t.Fatal("expected an id")
+	}
+
+	used, err := store.UseRefreshToken(token.Id)
+	check(t, err)
+	if !used {
+		t.Fatal("expected the first use to succeed")
+	}
+	used, err = store.UseRefreshToken(token.Id)
+	check(t, err)
+	if used {
+		t.Fatal("expected the second use to fail")
+	}
+
+	check(t, store.RevokeTokenFamily("family"))
+	loaded, err := store.GetRefreshToken("hash")
+	check(t, err)
+	if !loaded.Used || !loaded.Revoked {
+		t.Fatalf("expected a used and revoked token, got %+v", loaded)
+	}
+
+	check(t, store.DeleteExpiredRefreshTokens(created+60001))
+	_, err = store.GetRefreshToken("hash")
+	expectErr(t, ErrNotFound, err)
+}
+
+func testServersAndRooms(t *testing.T, store Store) {
+	second := &models.ChatServer{Name: "second", Description: "second", Position: 1}
+	check(t, store.CreateServer(second))
+	first := &models.ChatServer{Name: "first", Description: "first", Public: true}
+	check(t, store.CreateServer(first))
+	servers, err := store.ListServers()
+	check(t, err)
+	if len(servers) != 2 || servers[0].Id != first.Id || servers[1].Id != second.Id {
+		t.Fatalf("expected the servers by position, got %+v", servers)
+	}
+
+	first.Description = "updated"
+	check(t, store.UpdateServer(first))
+	loaded, err := store.GetServer(first.Id)
+	check(t, err)
+	if loaded.Description != "updated" || !loaded.Public {
+		t.Fatalf("unexpected server %+v", loaded)
+	}
+	_, err = store.GetServer(first.Id + second.Id)
+	expectErr(t, ErrNotFound, err)
+
+	room := &models.ChatRoom{Name: "room", Description: "room", ServerId: first.Id, Position: 1, Capacity: 4,
+		Private: true, MediaMode: "relay"}
+	check(t, store.CreateRoom(room))
+	lobby := &models.ChatRoom{Name: "lobby", Description: "lobby", ServerId: first.Id}
+	check(t, store.CreateRoom(lobby))
+	rooms, err := store.ListRooms(first.Id)
+	check(t, err)
+	if len(rooms) != 2 || rooms[0].Id != lobby.Id || rooms[1].Id != room.Id {
+		t.Fatalf("expected the rooms by position, got %+v", rooms)
+	}
+	if rooms[1].Server == nil || rooms[1].Server.Id != first.Id {
+		t.Fatalf("expected the server of the room, got %+v", rooms[1].Server)
+	}
+
+	room.Mixing = true
+	room.Capacity = 8
+	check(t, store.UpdateRoom(room))
+	loadedRoom, err := store.GetRoom(room.Id)
+	check(t, err)
+	if !loadedRoom.Mixing || loadedRoom.Capacity != 8 || !loadedRoom.Private || loadedRoom.MediaMode != "relay" {
+		t.Fatalf("unexpected room %+v", loadedRoom)
+	}
+
+	check(t, store.DeleteRoom(lobby.Id))
+	_, err = store.GetRoom(lobby.Id)
+	expectErr(t, ErrNotFound, err)
+}
+
+func testMembers(t *testing.T, store Store) {
+	server, room := createRoom(t, store)
+	other := &models.ChatServer{Name: "other", Description: "other"}
+	check(t, store.CreateServer(other))
+	alice := createUser(t, store, "alice")
+	bob := createUser(t, store, "bob")
+	createRole(t, store, models.RoleMember, "member")
+
+	check(t, store.CreateMember(&models.ChatServerMember{ServerId: server.Id, UserId: alice.Id, JoinedAt: now()}))
+	check(t, store.CreateMember(&models.ChatServerMember{ServerId: server.Id, UserId: bob.Id, JoinedAt: now()}))
+	check(t, store.CreateMember(&models.ChatServerMember{ServerId: other.Id, UserId: bob.Id, JoinedAt: now()}))
+	// joining twice keeps the first membership
+	check(t, store.CreateMember(&models.ChatServerMember{ServerId: server.Id, UserId: bob.Id, JoinedAt: now()}))
+
+	members, err := store.ListMembers(server.Id)
+	check(t, err)
+	if len(members) != 2 || members[0].User == nil {
+		t.Fatalf("expected two members with their users, got %+v", members)
+	}
+	servers, err := store.ListServersOf(alice.Id)
+	check(t, err)
+	if len(servers) != 1 || servers[0].Id != server.Id {
+		t.Fatalf("expected alice on one server, got %+v", servers)
+	}
+
+	check(t, store.SetNickname(server.Id, alice.Id, "ally"))
+	member, err := store.GetMember(server.Id, alice.Id)
+	check(t, err)
+	if member.Nickname != "ally" {
+		t.Fatalf("expected the nickname, got %q", member.Nickname)
+	}
+	expectErr(t, ErrNotFound, store.SetNickname(other.Id, alice.Id, "ally"))
+
+	// the roles of the user on the server and its rooms go with the membership
+	check(t, store.SetServerRole(alice.Id, server.Id, models.RoleMember))
+	check(t, store.SetRoomRole(alice.Id, room.Id, models.RoleMember))
+	check(t, store.DeleteMember(server.Id, alice.Id))
+	_, err = store.GetMember(server.Id, alice.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetServerRole(alice.Id, server.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetRoomRole(alice.Id, room.Id)
+	expectErr(t, ErrNotFound, err)
+	if _, err = store.GetMember(other.Id, bob.Id); err != nil {
+		t.Fatalf("membership on another server is gone: %v", err)
+	}
+}
+
+func testRoles(t *testing.T, store Store) {
+	server, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+	createRole(t, store, models.RoleModerator, "moderator")
+	createRole(t, store, models.RoleMember, "member")
+	roles, err := store.ListRoles()
+	check(t, err)
+	if len(roles) != 2 {
+		t.Fatalf("expected 2 roles, got %+v", roles)
+	}
+
+	check(t, store.SetServerRole(alice.Id, server.Id, models.RoleMember))
+	check(t, store.SetServerRole(alice.Id, server.Id, models.RoleModerator))
+	serverRole, err := store.GetServerRole(alice.Id, server.Id)
+	check(t, err)
+	if serverRole.RoleId != models.RoleModerator {
+		t.Fatalf("expected the role to be replaced, got %d", serverRole.RoleId)
+	}
+
+	check(t, store.SetRoomRole(alice.Id, room.Id, models.RoleMember))
+	overrides, err := store.ListRoomRolesOf(alice.Id)
+	check(t, err)
+	if len(overrides) != 1 || overrides[0].Room == nil || overrides[0].Role == nil ||
+		overrides[0].Role.Id != models.RoleMember {
+		t.Fatalf("expected the override with its room and role, got %+v", overrides)
+	}
+	check(t, store.DeleteRoomRole(alice.Id, room.Id))
+	_, err = store.GetRoomRole(alice.Id, room.Id)
+	expectErr(t, ErrNotFound, err)
+}
+
+func testRoomAccess(t *testing.T, store Store) {
+	_, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+	bob := createUser(t, store, "bob")
+
+	check(t, store.CreateRoomAccess(&models.ChatRoomAccess{RoomId: room.Id, UserId: alice.Id, CreatedBy: bob.Id, CreateAt: now()}))
+	check(t, store.CreateRoomAccess(&models.ChatRoomAccess{RoomId: room.Id, UserId: alice.Id, CreatedBy: bob.Id, CreateAt: now()}))
+	access, err := store.HasRoomAccess(room.Id, alice.Id)
+	check(t, err)
+	if !access {
+		t.Fatal("expected alice to have access")
+	}
+	access, err = store.HasRoomAccess(room.Id, bob.Id)
+	check(t, err)
+	if access {
+		t.Fatal("expected bob to have no access")
+	}
+	list, err := store.ListRoomAccess(room.Id)
+	check(t, err)
+	if len(list) != 1 || list[0].UserId != alice.Id {
+		t.Fatalf("unexpected access list %+v", list)
+	}
+
+	check(t, store.DeleteRoomAccess(room.Id, alice.Id))
+	access, err = store.HasRoomAccess(room.Id, alice.Id)
+	check(t, err)
+	if access {
+		t.Fatal("expected the access to be revoked")
+	}
+}
+
+func testBans(t *testing.T, store Store) {
+	server, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+	created := now()
+
+	permanent := &models.ChatBan{UserId: alice.Id, ServerId: server.Id, Reason: "spam", CreateAt: created}
+	check(t, store.CreateBan(permanent))
+	expired := &models.ChatBan{UserId: alice.Id, ServerId: server.Id, RoomId: room.Id, CreateAt: created - 2000, Expires: created - 1000}
+	check(t, store.CreateBan(expired))
+
+	bans, err := store.ListBans(server.Id, created)
+	check(t, err)
+	if len(bans) != 1 || bans[0].Id != permanent.Id || bans[0].Reason != "spam" {
+		t.Fatalf("expected only the permanent ban, got %+v", bans)
+	}
+	bans, err = store.ListBansOf(alice.Id, server.Id, room.Id)
+	check(t, err)
+	if len(bans) != 2 {
+		t.Fatalf("expected both bans of alice, got %+v", bans)
+	}
+
+	check(t, store.DeleteBan(permanent.Id))
+	_, err = store.GetBan(permanent.Id)
+	expectErr(t, ErrNotFound, err)
+}
+
+func testInvites(t *testing.T, store Store) {
+	server, _ := createRoom(t, store)
+	created := now()
+
+	invite := &models.ChatInvite{Code: "code", ServerId: server.Id, MaxUses: 2, CreateAt: created}
+	check(t, store.CreateInvite(invite))
+	expectErr(t, ErrConflict, store.CreateInvite(&models.ChatInvite{Code: "code", ServerId: server.Id, CreateAt: created}))
+	for i := 0; i < 3; i++ {
+		used, err := store.UseInvite(invite, created)
+		check(t, err)
+		if used != (i < 2) {
+			t.Fatalf("use %d: expected %v, got %v", i+1, i < 2, used)
+		}
+	}
+	loaded, err := store.GetInviteByCode("code")
+	check(t, err)
+	if loaded.Uses != 2 || invite.Uses != 2 {
+		t.Fatalf("expected 2 uses, got %d and %d", loaded.Uses, invite.Uses)
+	}
+
+	expiring := &models.ChatInvite{Code: "expiring", ServerId: server.Id, CreateAt: created, Expires: created + 1000}
+	check(t, store.CreateInvite(expiring))
+	used, err := store.UseInvite(expiring, created+2000)
+	check(t, err)
+	if used {
+		t.Fatal("expected the expired invite to be refused")
+	}
+
+	check(t, store.RevokeInvite(expiring.Id))
+	used, err = store.UseInvite(expiring, created)
+	check(t, err)
+	if used {
+		t.Fatal("expected the revoked invite to be refused")
+	}
+	invites, err := store.ListInvites(server.Id)
+	check(t, err)
+	if len(invites) != 2 {
+		t.Fatalf("expected 2 invites, got %+v", invites)
+	}
+}
+
+func testConnStats(t *testing.T, store Store) {
+	_, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+
+	check(t, store.CreateConnStats(&models.ChatUserConnStats{Id: "first", UserId: alice.Id, RoomId: room.Id}))
+	check(t, store.CreateConnStats(&models.ChatUserConnStats{Id: "second", UserId: alice.Id, RoomId: room.Id}))
+	stats, err := store.ListConnStats(alice.Id, room.Id)
+	check(t, err)
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 records, got %+v", stats)
+	}
+
+	check(t, store.DeleteConnStats("first"))
+	stats, err = store.ListConnStats(alice.Id, room.Id)
+	check(t, err)
+	if len(stats) != 1 || stats[0].Id != "second" {
+		t.Fatalf("expected the second record, got %+v", stats)
+	}
+	check(t, store.DeleteUserConnStats(alice.Id))
+	stats, err = store.ListConnStats(alice.Id, room.Id)
+	check(t, err)
+	if len(stats) != 0 {
+		t.Fatalf("expected no records, got %+v", stats)
+	}
+}
+
+func testDeleteServer(t *testing.T, store Store) {
+	server, room := createRoom(t, store)
+	alice := createUser(t, store, "alice")
+	createRole(t, store, models.RoleMember, "member")
+	created := now()
+	check(t, store.CreateMember(&models.ChatServerMember{ServerId: server.Id, UserId: alice.Id, JoinedAt: created}))
+	check(t, store.SetServerRole(alice.Id, server.Id, models.RoleMember))
+	check(t, store.SetRoomRole(alice.Id, room.Id, models.RoleMember))
+	check(t, store.CreateRoomAccess(&models.ChatRoomAccess{RoomId: room.Id, UserId: alice.Id, CreateAt: created}))
+	check(t, store.CreateBan(&models.ChatBan{UserId: alice.Id, ServerId: server.Id, RoomId: room.Id, CreateAt: created}))
+	check(t, store.CreateInvite(&models.ChatInvite{Code: "code", ServerId: server.Id, RoomId: room.Id, CreateAt: created}))
+	check(t, store.CreateConnStats(&models.ChatUserConnStats{Id: "conn", UserId: alice.Id, RoomId: room.Id}))
+
+	check(t, store.DeleteServer(server.Id))
+	_, err := store.GetServer(server.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetRoom(room.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetMember(server.Id, alice.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetServerRole(alice.Id, server.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetRoomRole(alice.Id, room.Id)
+	expectErr(t, ErrNotFound, err)
+	_, err = store.GetInviteByCode("code")
+	expectErr(t, ErrNotFound, err)
+	bans, err := store.ListBansOf(alice.Id, server.Id, room.Id)
+	check(t, err)
+	stats, err := store.ListConnStats(alice.Id, room.Id)
+	check(t, err)
+	if len(bans) != 0 || len(stats) != 0 {
+		t.Fatalf("expected no bans and records, got %+v and %+v", bans, stats)
+	}
+
+	// the user outlives the server
+	if _, err = store.GetUser(alice.Id); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -7,9 +7,8 @@ import (
 	"voice-chat-server/logger"
 )
 
-const (
-	SecretKey = "ChatServerSecretLey_Macarron"
-)
+// SecretKey signs and verifies the access tokens, it is replaced by the configured key at startup.
+var SecretKey = "ChatServerSecretLey_Macarron"
 
 func GetTokenFromRequest(r *http.Request) *jwt.Token {
 	token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor,